@@ -1,65 +1,207 @@
 package cmd
 
 import (
+	"bytes"
+	"flag"
 	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
 
 	"github.com/mythicmc/peridot/config"
 	"github.com/mythicmc/peridot/deploy"
 	"github.com/mythicmc/peridot/utils"
 )
 
+// interactivelyApplyUpdates applies the updates of each server in a transaction, rolling back the
+// server if any of its updates fail, with at most parallelism servers at once. The output of each
+// server is printed once it is done. It returns the servers which were rolled back.
 func interactivelyApplyUpdates(
 	configs config.Configs,
 	softwareUpdates map[string]deploy.SoftwareUpdateOperation,
 	serverPropertiesUpdates map[string][]deploy.ServerPropertiesUpdateOperation,
 	pluginUpdates map[string]map[string]deploy.PluginUpdateOperation,
-) {
-	for server, operation := range softwareUpdates {
-		fmt.Println("Updating server software for: ", server)
+	configFileUpdates map[string][]deploy.ConfigFileUpdateOperation,
+	parallelism int,
+) []string {
+	var mutex sync.Mutex
+	failedServers := make([]string, 0)
+	servers := affectedServers(softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates)
+	utils.RunParallel(servers, parallelism, func(server string) {
+		var softwareUpdate *deploy.SoftwareUpdateOperation
+		if operation, ok := softwareUpdates[server]; ok {
+			softwareUpdate = &operation
+		}
+		var out bytes.Buffer
+		ok := applyServerTransaction(&out, server, configs[server], softwareUpdate,
+			serverPropertiesUpdates[server], pluginUpdates[server], configFileUpdates[server])
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		os.Stdout.Write(out.Bytes())
+		if !ok {
+			failedServers = append(failedServers, server)
+		}
+	})
+	slices.Sort(failedServers)
+	return failedServers
+}
+
+// applyServerTransaction applies the updates of a single server in a transaction, returning false
+// if they failed and were rolled back.
+func applyServerTransaction(
+	out io.Writer,
+	server string,
+	config config.Config,
+	softwareUpdate *deploy.SoftwareUpdateOperation,
+	serverPropertiesUpdates []deploy.ServerPropertiesUpdateOperation,
+	pluginUpdates map[string]deploy.PluginUpdateOperation,
+	configFileUpdates []deploy.ConfigFileUpdateOperation,
+) bool {
+	transaction, err := deploy.BeginTransaction(server, deploy.TouchedPaths(
+		config, softwareUpdate, serverPropertiesUpdates, pluginUpdates, configFileUpdates))
+	if err != nil {
+		fmt.Fprintln(out, "Error snapshotting files for '"+server+"', skipping its updates:", err)
+		return false
+	}
+
+	err = applyServerUpdates(out, server, config,
+		softwareUpdate, serverPropertiesUpdates, pluginUpdates, configFileUpdates)
+	if err == nil {
+		id, err := transaction.Commit(deploy.Deployment{
+			Software:         softwareUpdate,
+			ServerProperties: serverPropertiesUpdates,
+			Plugins:          pluginUpdates,
+			ConfigFiles:      configFileUpdates,
+		})
+		if err != nil {
+			fmt.Fprintln(out, "Error recording deployment in history for '"+server+"':", err)
+		} else {
+			fmt.Fprintln(out, "Recorded deployment "+id+" in history for '"+server+"'")
+		}
+		return true
+	}
+
+	if err := transaction.Rollback(); err != nil {
+		fmt.Fprintln(out, "Error rolling back '"+server+"', server may be in a mixed state:", err)
+	} else {
+		fmt.Fprintln(out, "Rolled back all updates for '"+server+"'")
+	}
+	return false
+}
+
+// applyServerUpdates applies the updates of a single server, stopping at the first error.
+func applyServerUpdates(
+	out io.Writer,
+	server string,
+	config config.Config,
+	softwareUpdate *deploy.SoftwareUpdateOperation,
+	serverPropertiesUpdates []deploy.ServerPropertiesUpdateOperation,
+	pluginUpdates map[string]deploy.PluginUpdateOperation,
+	configFileUpdates []deploy.ConfigFileUpdateOperation,
+) error {
+	if softwareUpdate != nil {
+		fmt.Fprintln(out, "Updating server software for: ", server)
+
+		err := deploy.ApplySoftwareUpdate(*softwareUpdate)
+		if err != nil {
+			fmt.Fprintln(out, "Error updating server software for '"+server+"':", err)
+			return err
+		}
+	}
+
+	if len(serverPropertiesUpdates) > 0 {
+		fmt.Fprintln(out, "Updating server properties for: ", server)
 
-		err := deploy.ApplySoftwareUpdate(operation)
+		err := deploy.ApplyServerPropertiesUpdates(serverPropertiesUpdates, config)
 		if err != nil {
-			fmt.Println("Error updating server software for '"+server+"':", err)
+			fmt.Fprintln(out, "Error updating server properties for '"+server+"':", err)
+			return err
 		}
 	}
 
-	for server, operations := range serverPropertiesUpdates {
-		fmt.Println("Updating server properties for: ", server)
+	for _, name := range slices.Sorted(maps.Keys(pluginUpdates)) {
+		operation := pluginUpdates[name]
+		prevVersion := utils.PickNonEmptyString(operation.PrevVersion, "(missing)")
+		newVersion := utils.PickNonEmptyString(operation.NewVersion, "(removed)")
+		fmt.Fprintln(out, "Updating '"+server+"' plugin ", operation.PluginName,
+			" ("+prevVersion+" -> "+newVersion+")")
 
-		err := deploy.ApplyServerPropertiesUpdates(operations, configs[server])
+		err := deploy.ApplyPluginUpdate(operation)
 		if err != nil {
-			fmt.Println("Error updating server properties for '"+server+"':", err)
+			fmt.Fprintln(out, "Error updating plugins for '"+server+"':", err)
+			return err
 		}
 	}
 
-	for server, operations := range pluginUpdates {
-		for _, operation := range operations {
-			prevVersion := utils.PickNonEmptyString(operation.PrevVersion, "(missing)")
-			newVersion := utils.PickNonEmptyString(operation.NewVersion, "(removed)")
-			fmt.Println("Updating '"+server+"' plugin ", operation.PluginName,
-				" ("+prevVersion+" -> "+newVersion+")")
-
-			err := deploy.ApplyPluginUpdate(operation)
-			if err != nil {
-				fmt.Println("Error updating plugins for '"+server+"':", err)
-			}
+	for _, operation := range configFileUpdates {
+		fmt.Fprintln(out, "Updating '"+server+"' config file ", operation.Name)
+
+		err := deploy.ApplyConfigFileUpdate(operation)
+		if err != nil {
+			fmt.Fprintln(out, "Error updating config files for '"+server+"':", err)
+			return err
 		}
 	}
+	return nil
 }
 
-func HandleApplyLiveCommand(args []string) {
-	_, configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates := loadReposConfigUpdateState()
-	previewUpdates(softwareUpdates, serverPropertiesUpdates, pluginUpdates)
+// affectedServers returns the sorted names of all servers with pending updates.
+func affectedServers(
+	softwareUpdates map[string]deploy.SoftwareUpdateOperation,
+	serverPropertiesUpdates map[string][]deploy.ServerPropertiesUpdateOperation,
+	pluginUpdates map[string]map[string]deploy.PluginUpdateOperation,
+	configFileUpdates map[string][]deploy.ConfigFileUpdateOperation,
+) []string {
+	servers := make([]string, 0)
+	for server := range softwareUpdates {
+		servers = append(servers, server)
+	}
+	for server := range serverPropertiesUpdates {
+		servers = append(servers, server)
+	}
+	for server := range pluginUpdates {
+		servers = append(servers, server)
+	}
+	for server := range configFileUpdates {
+		servers = append(servers, server)
+	}
+	slices.Sort(servers)
+	return slices.Compact(servers)
+}
+
+func HandleApplyLiveCommand(fs *flag.FlagSet, args []string) int {
+	flags := addStateFlags(fs)
+	flags.Apply = true
+	yes := fs.Bool("yes", false, "apply updates without asking for confirmation")
+	planFile := fs.String("plan", "", "apply the plan saved in this `file` by plan -o, instead of the current config")
+	servers, err := parseFlags(fs, args)
+	if err != nil {
+		return parseErrorExitCode(err)
+	}
+
+	configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates, err :=
+		loadApplyUpdateState(servers, *planFile, flags)
+	if err != nil {
+		return ExitError
+	}
+	previewUpdates(softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates)
 
-	fmt.Print("Proceed to apply updates live? [y/N] ")
-	var response string
-	fmt.Scanln(&response)
-	if response != "y" && response != "Y" {
+	if !confirmApply("Proceed to apply updates live?", *yes) {
 		fmt.Println("Aborting live update.")
-		return
+		return ExitOK
 	}
 
-	interactivelyApplyUpdates(configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates)
+	failedServers := interactivelyApplyUpdates(configs,
+		softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates, flags.Parallelism)
+	if len(failedServers) > 0 {
+		fmt.Println("Updates failed for: " + strings.Join(failedServers, ", "))
+		return ExitError
+	}
 
 	fmt.Println("All updates have been applied successfully!")
+	return ExitOK
 }
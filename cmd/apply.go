@@ -1,80 +1,61 @@
 package cmd
 
 import (
+	"flag"
 	"fmt"
 	"log"
-	"sync"
-	"time"
-
-	"github.com/mythicmc/peridot/utils"
+	"slices"
+	"strings"
 )
 
-func HandleApplyCommand(args []string) {
-	_, configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates := loadReposConfigUpdateState()
-	previewUpdates(softwareUpdates, serverPropertiesUpdates, pluginUpdates)
-
-	fmt.Print("Proceed to apply updates? [y/N] ")
-	var response string
-	fmt.Scanln(&response)
-	if response != "y" && response != "Y" {
-		fmt.Println("Aborting update.")
-		return
+func HandleApplyCommand(fs *flag.FlagSet, args []string) int {
+	flags := addStateFlags(fs)
+	flags.Apply = true
+	fs.BoolVar(&flags.RotateForwardingSecret, "rotate-forwarding-secret", false,
+		"generate new modern forwarding secrets for the selected Velocity proxies, which must be\n"+
+			"selected along with all of their servers")
+	yes := fs.Bool("yes", false, "apply updates without asking for confirmation")
+	planFile := fs.String("plan", "", "apply the plan saved in this `file` by plan -o, instead of the current config")
+	servers, err := parseFlags(fs, args)
+	if err != nil {
+		return parseErrorExitCode(err)
 	}
 
-	affectedServers := make(map[string]struct{})
-	for server := range softwareUpdates {
-		affectedServers[server] = struct{}{}
-	}
-	for server := range serverPropertiesUpdates {
-		affectedServers[server] = struct{}{}
-	}
-	for server := range pluginUpdates {
-		affectedServers[server] = struct{}{}
+	configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates, err :=
+		loadApplyUpdateState(servers, *planFile, flags)
+	if err != nil {
+		return ExitError
 	}
+	previewUpdates(softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates)
 
-	// Send stop signals to all servers
-	fmt.Println("Stopping affected servers via Octyne...")
-	for server := range affectedServers {
-		err := utils.OctyneTerminateServer(server)
-		if err != nil {
-			log.Fatalf("Error stopping server %s: %v\n", server, err)
-		}
+	if !confirmApply("Proceed to apply updates?", *yes) {
+		fmt.Println("Aborting update.")
+		return ExitOK
 	}
 
-	// Wait for servers to stop
-	var wg sync.WaitGroup
-	for server := range affectedServers {
-		wg.Add(1)
-		go func(s string) {
-			defer wg.Done()
-			for {
-				<-time.After(2 * time.Second)
-				status, err := utils.OctyneGetServerStatus(s)
-				if err != nil {
-					log.Fatalf("Error getting status for server %s: %v\n", s, err)
-				} else if status == utils.OctyneServerStatusStopped ||
-					status == utils.OctyneServerStatusCrashed {
-					break
-				}
-			}
-		}(server)
-	}
-	select {
-	case <-time.After(60 * time.Second):
-		log.Fatalln("Failed to stop all servers after 60 seconds! Exiting...")
-	case <-utils.WaitGroupDoneAsChannel(&wg):
+	// New servers aren't running yet, so they are neither stopped nor started
+	affectedServers := slices.DeleteFunc(affectedServers(
+		softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates),
+		func(server string) bool { return softwareUpdates[server].NewServer })
+	octyne, err := connectOctyne(affectedServers, configs)
+	if err != nil {
+		log.Println("An error has occurred while connecting to Octyne:", err)
+		return ExitError
+	} else if err := stopServers(affectedServers, octyne); err != nil {
+		return ExitError
 	}
 
-	interactivelyApplyUpdates(configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates)
+	failedServers := interactivelyApplyUpdates(configs,
+		softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates, flags.Parallelism)
+
+	// Rolled back servers are started in their previous state
+	startServers(affectedServers, octyne)
 
-	// Send start signals to all servers
-	fmt.Println("Starting affected servers via Octyne...")
-	for server := range affectedServers {
-		err := utils.OctyneStartServer(server)
-		if err != nil {
-			log.Printf("Error starting server %s: %v\n", server, err)
-		}
+	if len(failedServers) > 0 {
+		fmt.Println("Updates failed for: " + strings.Join(failedServers, ", "))
+		return ExitError
 	}
 
 	fmt.Println("All updates have been applied successfully!")
+	return ExitOK
 }
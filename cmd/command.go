@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/mythicmc/peridot/utils"
+)
+
+const (
+	ExitOK    = 0 // Command completed successfully
+	ExitError = 1 // Command failed while loading or applying state
+	ExitUsage = 2 // Command was invoked with invalid arguments
+	ExitDrift = 3 // Command found servers which differ from their desired state
+)
+
+type Command struct {
+	Name        string
+	Aliases     []string
+	Usage       string
+	Summary     string
+	Description string
+	Handler     func(fs *flag.FlagSet, args []string) int
+}
+
+var Commands = []Command{
+	{
+		Name:    "status",
+		Aliases: []string{"state"},
+		Usage:   "[options] [server...]",
+		Summary: "Show current state of configured Minecraft servers",
+		Description: "Show current state of configured Minecraft servers.\n" +
+			"This command compares the current state of the server files on disk with the\n" +
+			"desired state defined in Peridot's configuration.\n" +
+			"If servers are specified, it shows only their state.\n" +
+			"Exits with status code 3 if any server differs from its desired state, or with\n" +
+			"status code 1 if the updates of any server failed to be prepared.",
+		Handler: HandleStateCommand,
+	},
+	{
+		Name:    "plan",
+		Usage:   "[options] [server...]",
+		Summary: "Show and optionally save the updates apply would make",
+		Description: "Show the updates apply would make to the configured Minecraft servers.\n" +
+			"With -o, the plan is saved to a file along with the checksums of the files it was\n" +
+			"computed from. The saved plan can then be applied as-is with apply.\n" +
+			"If servers are specified, plan updates for those servers only.",
+		Handler: HandlePlanCommand,
+	},
+	{
+		Name:    "apply",
+		Usage:   "[options] [server...]",
+		Summary: "Apply current config to Minecraft server files",
+		Description: "Apply current config to the Minecraft server files on disk.\n" +
+			"This command will restart the server(s) if possible.\n" +
+			"If servers are specified, apply the config to those servers only.\n" +
+			"With -plan, apply a saved plan instead, refusing to do so if the server files or\n" +
+			"repository JARs have changed since the plan was made.",
+		Handler: HandleApplyCommand,
+	},
+	{
+		Name:    "apply-live",
+		Usage:   "[options] [server...]",
+		Summary: "Apply current config to Minecraft server files, without restarts",
+		Description: "Apply current config to the Minecraft server files on disk, without restarts.\n" +
+			"If servers are specified, apply the config to those servers only.\n" +
+			"With -plan, apply a saved plan instead.",
+		Handler: HandleApplyLiveCommand,
+	},
+	{
+		Name:    "lock",
+		Usage:   "[options]",
+		Summary: "Lock the software and plugins resolved for every server",
+		Description: "Record the software and plugin JARs resolved for every server in peridot.lock.\n" +
+			"Once it exists, apply deploys the locked JARs instead of the newest ones in the\n" +
+			"repositories, and refuses to run if the lock no longer satisfies the configs.\n" +
+			"Locked JARs which still satisfy the configs are kept, use update to upgrade them.",
+		Handler: HandleLockCommand,
+	},
+	{
+		Name:    "update",
+		Usage:   "[options] [server] [plugin...]",
+		Summary: "Update locked software and plugins to the newest versions",
+		Description: "Update the JARs locked in peridot.lock to the newest ones matching the configs.\n" +
+			"If a server is specified, only the JARs of the matching servers are updated.\n" +
+			"If plugins are specified as well, only those plugins are updated.",
+		Handler: HandleUpdateCommand,
+	},
+	{
+		Name:    "history",
+		Usage:   "[options] (server)",
+		Summary: "Show the deployment history of a Minecraft server",
+		Description: "Show the deployments applied to a Minecraft server, oldest first.\n" +
+			"Each deployment keeps a backup of the files it replaced, so it can be rolled back.",
+		Handler: HandleHistoryCommand,
+	},
+	{
+		Name:    "rollback",
+		Usage:   "[options] (server) [deployment-id]",
+		Summary: "Roll back a Minecraft server to a previous deployment",
+		Description: "Restore the files of a Minecraft server to their state before a deployment,\n" +
+			"undoing it and every deployment made after it. If no deployment is specified,\n" +
+			"the latest deployment is undone. The rollback itself is recorded in the history.\n" +
+			"This command will restart the server if possible.",
+		Handler: HandleRollbackCommand,
+	},
+	{
+		Name:    "import",
+		Usage:   "[options] (server) (path)",
+		Summary: "Generate a config from an existing Minecraft server",
+		Description: "Generate the config of a server from an existing Minecraft server folder.\n" +
+			"The server software, plugins and server.properties are inspected, and the\n" +
+			"repositories with identical JARs are selected. JARs which aren't in any\n" +
+			"repository are left commented out, unless -copy-unknown copies them into a\n" +
+			"new repository.",
+		Handler: HandleImportCommand,
+	},
+	{
+		Name:    "destroy",
+		Aliases: []string{"decommission"},
+		Usage:   "[options] (server)",
+		Summary: "Archive and retire a Minecraft server",
+		Description: "Decommission a Minecraft server, stopping it via Octyne and archiving its folder\n" +
+			"and config to a tarball in the archives/ folder, along with a manifest of its files.\n" +
+			"Its config is then removed, along with its entries in generated Velocity server\n" +
+			"lists. With -delete, the server's folder is deleted once it has been archived.",
+		Handler: HandleDestroyCommand,
+	},
+}
+
+func FindCommand(name string) (Command, bool) {
+	for _, command := range Commands {
+		if command.Name == name {
+			return command, true
+		}
+		for _, alias := range command.Aliases {
+			if alias == name {
+				return command, true
+			}
+		}
+	}
+	return Command{}, false
+}
+
+// Run parses the arguments of the command and executes it, returning the exit code.
+func (c Command) Run(program string, args []string) int {
+	fs := flag.NewFlagSet(program+" "+c.Name, flag.ContinueOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "Usage: "+program+" "+c.Name+" "+c.Usage)
+		for _, alias := range c.Aliases {
+			fmt.Fprintln(out, "    OR "+program+" "+alias+" "+c.Usage)
+		}
+		fmt.Fprintln(out, "")
+		fmt.Fprintln(out, utils.PickNonEmptyString(c.Description, c.Summary+"."))
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintln(out, "")
+			fmt.Fprintln(out, "Options:")
+			fs.PrintDefaults()
+		}
+	}
+	return c.Handler(fs, args)
+}
+
+// parseFlags parses flags interspersed with positional arguments, unlike flag.FlagSet.Parse
+// which stops at the first positional argument. Arguments after "--" are always positional.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0)
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		} else if len(args) > len(rest) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// parseErrorExitCode returns the exit code for an error returned by parseFlags.
+func parseErrorExitCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return ExitOK
+	}
+	return ExitUsage
+}
+
+type stringListFlag []string
+
+func (s *stringListFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringListFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*s = append(*s, item)
+		}
+	}
+	return nil
+}
+
+// stateFlags are the flags shared by commands which load the state of servers.
+type stateFlags struct {
+	Tags        stringListFlag
+	Parallelism int
+	NoCache     bool
+
+	RotateForwardingSecret bool // Only registered by apply
+	Apply                  bool // Set by commands applying the loaded state, generating missing secrets
+}
+
+func addStateFlags(fs *flag.FlagSet) *stateFlags {
+	flags := &stateFlags{}
+	fs.Var(&flags.Tags, "tag",
+		"only select servers with this `tag` (can be repeated or comma-separated)")
+	fs.IntVar(&flags.Parallelism, "parallelism", runtime.NumCPU(),
+		"maximum `number` of servers to process at once")
+	fs.BoolVar(&flags.NoCache, "no-cache", false,
+		"read and hash every JAR instead of using the cached checksums and metadata")
+	return flags
+}
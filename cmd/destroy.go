@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"slices"
+
+	"github.com/mythicmc/peridot/config"
+	"github.com/mythicmc/peridot/deploy"
+)
+
+func HandleDestroyCommand(fs *flag.FlagSet, args []string) int {
+	yes := fs.Bool("yes", false, "decommission without asking for confirmation")
+	dryRun := fs.Bool("dry-run", false, "only show what decommissioning the server would do")
+	deleteLocation := fs.Bool("delete", false, "delete the server's folder once it has been archived")
+	live := fs.Bool("live", false, "archive the server without stopping it via Octyne")
+	noCache := fs.Bool("no-cache", false,
+		"read and hash every JAR instead of using the cached checksums and metadata")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return parseErrorExitCode(err)
+	} else if len(args) != 1 {
+		fs.Usage()
+		return ExitUsage
+	}
+	server := args[0]
+
+	cache := loadJarCache(*noCache)
+	defer func() {
+		if err := cache.Save(); err != nil {
+			log.Println("Warning: Failed to save JAR cache:", err)
+		}
+	}()
+	repositories, configs, err := loadReposConfigs(cache)
+	if err != nil {
+		return ExitError
+	}
+	serverConfig, ok := configs[server]
+	if !ok {
+		log.Println("An error has occurred while selecting servers:", config.UnknownServerError{Name: server})
+		return ExitError
+	} else if err := configs.CheckUnreferenced(server); err != nil {
+		log.Println("An error has occurred while preparing decommission:", err)
+		return ExitError
+	}
+	operation, err := deploy.PrepareDecommission(server, serverConfig, *deleteLocation)
+	if err != nil {
+		log.Println("An error has occurred while preparing decommission:", err)
+		return ExitError
+	}
+
+	// Regenerate the server lists of the proxies the server is registered on, without the server
+	remaining, err := config.LoadConfigs(repositories, server)
+	if err != nil {
+		log.Println("An error has occurred while loading configuration:", err)
+		return ExitError
+	} else if err := deploy.ResolveForwardingSecrets(remaining, false); err != nil {
+		log.Println("An error has occurred while loading forwarding secrets:", err)
+		return ExitError
+	}
+	configFileUpdates := make(map[string][]deploy.ConfigFileUpdateOperation)
+	for _, proxy := range slices.Sorted(maps.Keys(configs)) {
+		servers, _ := configs[proxy].VelocityServerTables["servers"].(map[string]interface{})
+		if _, ok := servers[server]; !ok {
+			continue
+		}
+		operations, err := deploy.PrepareConfigFileUpdates(proxy, remaining[proxy])
+		if err != nil {
+			log.Println("An error has occurred while preparing config file updates:", err)
+			return ExitError
+		}
+		for _, operation := range operations {
+			if operation.Name == "velocity.toml" {
+				configFileUpdates[proxy] = append(configFileUpdates[proxy], operation)
+			}
+		}
+	}
+
+	previewDecommission(operation)
+	fmt.Println("==============================")
+	previewConfigFileUpdates(configFileUpdates)
+	if *dryRun {
+		return ExitOK
+	} else if !confirmApply("Proceed to decommission '"+server+"'?", *yes) {
+		fmt.Println("Aborting decommission.")
+		return ExitOK
+	}
+
+	var octyne map[string]octyneServer
+	if !*live && operation.LocationExists {
+		octyne, err = connectOctyne([]string{server}, configs)
+		if err != nil {
+			log.Println("An error has occurred while connecting to Octyne:", err)
+			return ExitError
+		} else if err := stopServers([]string{server}, octyne); err != nil {
+			return ExitError
+		}
+	}
+
+	fmt.Println("Archiving '" + server + "' to " + operation.ArchivePath)
+	manifest, err := deploy.ArchiveServer(operation)
+	if err != nil {
+		fmt.Println("Error archiving '"+server+"', leaving it in place:", err)
+		if octyne != nil {
+			startServers([]string{server}, octyne)
+		}
+		return ExitError
+	}
+	fmt.Printf("Archived %d files of '%s'\n", len(manifest.Files), server)
+
+	// Proxies pick up their new server list without being restarted
+	failed := false
+	for _, proxy := range slices.Sorted(maps.Keys(configFileUpdates)) {
+		if !applyServerTransaction(os.Stdout, proxy, remaining[proxy], nil, nil, nil, configFileUpdates[proxy]) {
+			failed = true
+		}
+	}
+
+	if err := deploy.RemoveServer(operation); err != nil {
+		fmt.Println("Error removing '"+server+"':", err)
+		return ExitError
+	} else if failed {
+		fmt.Println("Decommissioned '" + server + "', but its proxies could not be updated")
+		return ExitError
+	}
+	fmt.Println("Server '" + server + "' has been decommissioned successfully!")
+	return ExitOK
+}
+
+func previewDecommission(operation deploy.DecommissionOperation) {
+	fmt.Println("Pending decommission of '" + operation.Server + "':")
+	if operation.LocationExists {
+		fmt.Println(" - Archive " + operation.Location + " and config to " + operation.ArchivePath)
+	} else {
+		fmt.Println(" - Archive config to " + operation.ArchivePath + " (" + operation.Location + " doesn't exist)")
+	}
+	fmt.Println(" - Remove config " + operation.ConfigPath)
+	if operation.DeleteLocation && operation.LocationExists {
+		fmt.Println(" - Delete " + operation.Location)
+	}
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"slices"
+	"strconv"
+
+	"github.com/mythicmc/peridot/deploy"
+	"github.com/mythicmc/peridot/utils"
+)
+
+func HandleHistoryCommand(fs *flag.FlagSet, args []string) int {
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return parseErrorExitCode(err)
+	} else if len(args) != 1 {
+		fs.Usage()
+		return ExitUsage
+	}
+	server := args[0]
+
+	history, err := deploy.LoadHistory(server)
+	if err != nil {
+		log.Println("An error has occurred while loading history:", err)
+		return ExitError
+	} else if len(history) == 0 {
+		fmt.Println("No deployments found in history for '" + server + "'")
+		return ExitOK
+	}
+
+	fmt.Println("Deployment history for '" + server + "' (oldest first):")
+	for _, deployment := range history {
+		fmt.Println(" - " + deployment.ID + " (" + deployment.CreatedAt.Local().Format("2006-01-02 15:04:05") + ")")
+		previewDeployment(deployment)
+	}
+	return ExitOK
+}
+
+func previewDeployment(deployment deploy.Deployment) {
+	if deployment.RollbackOf != "" {
+		fmt.Println("    => Rollback to before " + deployment.RollbackOf)
+	}
+	if deployment.Software != nil {
+		prevHash := utils.PickNonEmptyString(utils.ShortHash(deployment.Software.PrevHash), "(missing)")
+		newHash := utils.PickNonEmptyString(utils.ShortHash(deployment.Software.NewHash), "(removed)")
+		fmt.Printf("    => Software %s: %s -> %s\n", deployment.Software.SoftwareType, prevHash, newHash)
+	}
+	if len(deployment.ServerProperties) > 0 {
+		fmt.Println("    => Updated " + strconv.Itoa(len(deployment.ServerProperties)) + " server properties")
+	}
+	for _, update := range deployment.Plugins {
+		prevVersion := utils.PickNonEmptyString(update.PrevVersion, "(missing)")
+		newVersion := utils.PickNonEmptyString(update.NewVersion, "(removed)")
+		fmt.Printf("    => Plugin %s: %s -> %s\n", update.PluginName, prevVersion, newVersion)
+	}
+	for _, update := range deployment.ConfigFiles {
+		fmt.Println("    => Updated config file " + update.Name)
+	}
+}
+
+func HandleRollbackCommand(fs *flag.FlagSet, args []string) int {
+	yes := fs.Bool("yes", false, "roll back without asking for confirmation")
+	live := fs.Bool("live", false, "roll back without restarting the server via Octyne")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return parseErrorExitCode(err)
+	} else if len(args) != 1 && len(args) != 2 {
+		fs.Usage()
+		return ExitUsage
+	}
+	server := args[0]
+	id := ""
+	if len(args) == 2 {
+		id = args[1]
+	}
+
+	history, err := deploy.LoadHistory(server)
+	if err != nil {
+		log.Println("An error has occurred while loading history:", err)
+		return ExitError
+	}
+	index, err := deploy.FindDeployment(server, history, id)
+	if err != nil {
+		log.Println("An error has occurred while finding deployment:", err)
+		return ExitError
+	}
+
+	// Undo the deployments from newest to the target one, to restore the state before it
+	deployments := slices.Clone(history[index:])
+	slices.Reverse(deployments)
+	fmt.Println("Deployments to roll back on '" + server + "' (newest first):")
+	paths := make([]string, 0)
+	for _, deployment := range deployments {
+		fmt.Println(" - " + deployment.ID)
+		previewDeployment(deployment)
+		for _, file := range deployment.Files {
+			paths = append(paths, file.Path)
+		}
+	}
+
+	if !confirmApply("Proceed to roll back?", *yes) {
+		fmt.Println("Aborting rollback.")
+		return ExitOK
+	}
+
+	var octyne map[string]octyneServer
+	if !*live {
+		configs, err := loadOctyneConfigs([]string{server})
+		if err != nil {
+			log.Println("An error has occurred while loading Octyne settings:", err)
+			log.Println("Pass --live to roll back without stopping the server via Octyne.")
+			return ExitError
+		}
+		octyne, err = connectOctyne([]string{server}, configs)
+		if err != nil {
+			log.Println("An error has occurred while connecting to Octyne:", err)
+			return ExitError
+		} else if err := stopServers([]string{server}, octyne); err != nil {
+			return ExitError
+		}
+	}
+
+	failed := false
+	transaction, err := deploy.BeginTransaction(server, paths)
+	if err != nil {
+		fmt.Println("Error snapshotting files for '"+server+"', skipping rollback:", err)
+		failed = true
+	} else {
+		for _, deployment := range deployments {
+			fmt.Println("Restoring files replaced by deployment " + deployment.ID)
+			if err = deploy.RestoreDeployment(server, deployment); err != nil {
+				fmt.Println("Error restoring files replaced by deployment "+deployment.ID+":", err)
+				break
+			}
+		}
+		if err != nil {
+			failed = true
+			if err := transaction.Rollback(); err != nil {
+				fmt.Println("Error undoing rollback of '"+server+"', server may be in a mixed state:", err)
+			}
+		} else if id, err := transaction.Commit(deploy.Deployment{RollbackOf: deployments[len(deployments)-1].ID}); err != nil {
+			fmt.Println("Error recording rollback in history for '"+server+"':", err)
+		} else {
+			fmt.Println("Recorded rollback as deployment " + id + " in history for '" + server + "'")
+		}
+	}
+
+	if !*live {
+		startServers([]string{server}, octyne)
+	}
+
+	if failed {
+		fmt.Println("Rollback failed for: " + server)
+		return ExitError
+	}
+	fmt.Println("Rollback has been completed successfully!")
+	return ExitOK
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mythicmc/peridot/config"
+	"github.com/mythicmc/peridot/repos"
+	"github.com/mythicmc/peridot/utils"
+)
+
+var errInvalidServerName = errors.New("invalid server name: must be a file name without path separators")
+
+var errConfigExists = errors.New("config already exists, use -force to overwrite it")
+
+var errRepoExists = errors.New("repository already exists, JARs can only be copied into a new repository")
+
+func HandleImportCommand(fs *flag.FlagSet, args []string) int {
+	copyUnknown := fs.String("copy-unknown", "",
+		"copy JARs which aren't in any repository into a new repository with this `name`")
+	force := fs.Bool("force", false, "overwrite the config if it already exists")
+	dryRun := fs.Bool("dry-run", false, "print the generated config instead of writing it")
+	noCache := fs.Bool("no-cache", false,
+		"read and hash every JAR instead of using the cached checksums and metadata")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return parseErrorExitCode(err)
+	} else if len(args) != 2 {
+		fs.Usage()
+		return ExitUsage
+	}
+	name, location := args[0], args[1]
+	if name == "" || strings.ContainsAny(name, `/\`) || strings.HasPrefix(name, ".") {
+		log.Println("An error has occurred while importing server:", errInvalidServerName)
+		return ExitUsage
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Println("An error has occurred while importing server:", err)
+		return ExitError
+	}
+	configFolder := filepath.Join(wd, "configs")
+	configPath := filepath.Join(configFolder, name+".js")
+	if _, err := os.Stat(configPath); err == nil && !*force && !*dryRun {
+		log.Println("An error has occurred while importing server:", errConfigExists)
+		return ExitError
+	}
+	repoPath := filepath.Join(wd, "repos", *copyUnknown)
+	if _, err := os.Stat(repoPath); *copyUnknown != "" && err == nil {
+		log.Println("An error has occurred while importing server:", errRepoExists)
+		return ExitError
+	}
+
+	cache := loadJarCache(*noCache)
+	defer func() {
+		if err := cache.Save(); err != nil {
+			log.Println("Warning: Failed to save JAR cache:", err)
+		}
+	}()
+	repositories, err := repos.LoadRepositories(cache)
+	if err != nil {
+		log.Println("An error has occurred while loading repositories:", err)
+		return ExitError
+	}
+	server, err := config.InspectServer(location, repositories, cache)
+	if err != nil {
+		log.Println("An error has occurred while inspecting server:", err)
+		return ExitError
+	}
+
+	// Copy the unknown JARs into a new repository, which takes precedence over the others
+	unknownJars := server.UnknownJars()
+	if *copyUnknown != "" && len(unknownJars) > 0 {
+		for _, jar := range unknownJars {
+			dst := filepath.Join(repoPath, filepath.Base(jar.Path))
+			if *dryRun {
+				fmt.Println("Would copy " + jar.Path + " to " + dst)
+			} else if err := copyImportedJar(jar, dst); err != nil {
+				log.Println("An error has occurred while copying JARs into repository:", err)
+				return ExitError
+			}
+		}
+		server.AddRepo(*copyUnknown, unknownJars)
+		if !*dryRun {
+			repositories[*copyUnknown], err = repos.LoadRepository(repoPath, *copyUnknown, cache)
+			if err != nil {
+				log.Println("An error has occurred while loading repositories:", err)
+				return ExitError
+			}
+		}
+	}
+	previewImport(name, server)
+
+	content := config.GenerateConfigFile(server)
+	if *dryRun {
+		fmt.Println("==============================")
+		fmt.Print(content)
+		return ExitOK
+	} else if err := os.MkdirAll(configFolder, 0755); err != nil {
+		log.Println("An error has occurred while writing config:", err)
+		return ExitError
+	} else if err := utils.WriteFileAtomic(configPath, []byte(content), 0644); err != nil {
+		log.Println("An error has occurred while writing config:", err)
+		return ExitError
+	}
+	fmt.Println("Wrote config to " + configPath)
+
+	// Check the generated config, which is invalid if some JARs couldn't be found in repositories
+	importedConfig, err := config.ExecuteConfig(configFolder, content)
+	if err == nil {
+		err = config.ValidateConfig(importedConfig, repositories)
+	}
+	if err != nil {
+		log.Println("Warning: The generated config is not valid yet, edit it before applying:", err)
+	} else {
+		fmt.Println("Run 'peridot status " + name + "' to compare it with the server.")
+	}
+	return ExitOK
+}
+
+// copyImportedJar copies a JAR into a repository, refusing to overwrite existing files.
+func copyImportedJar(jar config.ImportedJar, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	} else if _, err := os.Stat(dst); err == nil {
+		return os.ErrExist
+	}
+	fmt.Println("Copying " + jar.Path + " to " + dst)
+	return utils.CopyFileVerified(jar.Path, dst, jar.Checksum)
+}
+
+func previewImport(name string, server config.ImportedServer) {
+	fmt.Println("Importing '" + name + "' from " + server.Location)
+	if server.Software == nil {
+		fmt.Println(" - Software: (missing)")
+	} else {
+		fmt.Println(" - Software: " + server.Software.Name + " (" + importedJarSource(*server.Software, server) + ")")
+		if expected := server.Software.Name + ".jar"; filepath.Base(server.Software.Path) != expected {
+			log.Printf("Warning: Peridot installs the server software as %s, rename %s or update the "+
+				"server's start command before applying\n", expected, filepath.Base(server.Software.Path))
+		}
+	}
+	for _, plugin := range server.Plugins {
+		fmt.Println(" - Plugin " + plugin.Name + " " + plugin.Version + " (" + importedJarSource(plugin, server) + ")")
+	}
+	if server.ServerProperties != nil {
+		fmt.Printf(" - Server properties: %d\n", len(server.ServerProperties))
+	}
+	fmt.Println(" - Repositories: " + utils.PickNonEmptyString(strings.Join(server.Repos, ", "), "(none)"))
+}
+
+// importedJarSource describes where the JAR of an imported server can be found.
+func importedJarSource(jar config.ImportedJar, server config.ImportedServer) string {
+	for _, repo := range slices.Backward(server.Repos) {
+		if slices.Contains(jar.Repos, repo) {
+			return "found in " + repo
+		}
+	}
+	if len(jar.Others) > 0 {
+		return "not in any repository, other versions are in: " + strings.Join(jar.Others, ", ")
+	}
+	return "not in any repository"
+}
@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+
+	"github.com/mythicmc/peridot/config"
+	"github.com/mythicmc/peridot/utils"
+)
+
+type unrequiredPluginError struct{ Name string }
+
+func (e unrequiredPluginError) Error() string {
+	return "plugin " + e.Name + " isn't required by any selected server"
+}
+
+func HandleLockCommand(fs *flag.FlagSet, args []string) int {
+	dryRun := fs.Bool("dry-run", false, "only show the changes which would be made to peridot.lock")
+	noCache := fs.Bool("no-cache", false,
+		"read and hash every JAR instead of using the cached checksums and metadata")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return parseErrorExitCode(err)
+	} else if len(args) != 0 {
+		fs.Usage()
+		return ExitUsage
+	}
+	return updateLockfile(nil, nil, *dryRun, *noCache)
+}
+
+func HandleUpdateCommand(fs *flag.FlagSet, args []string) int {
+	dryRun := fs.Bool("dry-run", false, "only show the changes which would be made to peridot.lock")
+	noCache := fs.Bool("no-cache", false,
+		"read and hash every JAR instead of using the cached checksums and metadata")
+	args, err := parseFlags(fs, args)
+	if err != nil {
+		return parseErrorExitCode(err)
+	}
+	servers := []string{"*"}
+	if len(args) > 0 {
+		servers = args[:1]
+	}
+	var plugins []string
+	if len(args) > 1 {
+		plugins = args[1:]
+	}
+	return updateLockfile(servers, plugins, *dryRun, *noCache)
+}
+
+// updateLockfile locks every server, keeping their locked JARs which still satisfy their configs.
+// The JARs of the servers matching the given patterns are updated to the newest ones, only
+// updating the given plugins if any are specified.
+func updateLockfile(servers []string, plugins []string, dryRun bool, noCache bool) int {
+	cache := loadJarCache(noCache)
+	defer func() {
+		if err := cache.Save(); err != nil {
+			log.Println("Warning: Failed to save JAR cache:", err)
+		}
+	}()
+	repositories, configs, err := loadReposConfigs(cache)
+	if err != nil {
+		return ExitError
+	}
+	updated := make(config.Configs)
+	if len(servers) > 0 {
+		updated, err = configs.Select(servers, nil)
+		if err != nil {
+			log.Println("An error has occurred while selecting servers:", err)
+			return ExitError
+		}
+	}
+	for _, plugin := range plugins {
+		if !slices.ContainsFunc(slices.Collect(maps.Values(updated)), func(config config.Config) bool {
+			return config.HasPlugin(plugin)
+		}) {
+			log.Println("An error has occurred while selecting plugins:", unrequiredPluginError{Name: plugin})
+			return ExitUsage
+		}
+	}
+
+	lockfile, err := config.LoadLockfile()
+	if err != nil {
+		log.Println("An error has occurred while loading peridot.lock:", err)
+		return ExitError
+	} else if lockfile == nil {
+		lockfile = &config.Lockfile{Servers: make(map[string]config.LockedServer)}
+	}
+	changes := make([]config.LockChange, 0)
+	for _, server := range slices.Sorted(maps.Keys(configs)) {
+		serverConfig := configs[server]
+		updateSoftware, updatePlugins := lockUpdateTargets(server, serverConfig, updated, plugins)
+		serverChanges, err := lockfile.LockServer(server, serverConfig, repositories, updateSoftware, updatePlugins)
+		if err != nil {
+			log.Println("An error has occurred while locking server "+server+":", err)
+			return ExitError
+		}
+		changes = append(changes, serverChanges...)
+	}
+	// Servers whose configs were removed are no longer locked
+	removed := make([]string, 0)
+	for _, server := range slices.Sorted(maps.Keys(lockfile.Servers)) {
+		if _, ok := configs[server]; !ok {
+			removed = append(removed, server)
+			delete(lockfile.Servers, server)
+		}
+	}
+
+	if len(changes) == 0 && len(removed) == 0 {
+		fmt.Println("peridot.lock: Up to date")
+	} else {
+		fmt.Println("Pending peridot.lock updates:")
+		for _, change := range changes {
+			fmt.Println(" - " + change.Server + ": " + change.Name + " " + lockChangeString(change))
+		}
+		for _, server := range removed {
+			fmt.Println(" - " + server + ": (removed)")
+		}
+	}
+	if dryRun {
+		return ExitOK
+	} else if err := lockfile.Save(); err != nil {
+		log.Println("An error has occurred while saving peridot.lock:", err)
+		return ExitError
+	}
+	if len(changes) > 0 || len(removed) > 0 {
+		fmt.Println("Updated peridot.lock! Run 'peridot apply' to deploy the locked JARs.")
+	}
+	return ExitOK
+}
+
+// lockUpdateTargets returns whether the software of a server should be updated and which of its
+// plugins should be, given the servers and plugins being updated. Only the given plugins are updated
+// if there are any, otherwise the software and every plugin of the updated servers are.
+func lockUpdateTargets(
+	server string, serverConfig config.Config, updated config.Configs, plugins []string,
+) (bool, []string) {
+	if _, ok := updated[server]; !ok {
+		return false, nil
+	} else if len(plugins) > 0 {
+		return false, plugins
+	}
+	updatePlugins := make([]string, 0, len(serverConfig.Plugins))
+	for _, plugin := range serverConfig.Plugins {
+		updatePlugins = append(updatePlugins, plugin.Name)
+	}
+	return true, updatePlugins
+}
+
+// lockChangeString formats the old and new versions of a locked plugin, or the old and new checksums
+// of locked software, along with the checksums of plugins rebuilt without changing their version.
+func lockChangeString(change config.LockChange) string {
+	jarString := func(jar *config.LockedJar) string {
+		if jar.Type != "" {
+			return utils.ShortHash(jar.Checksum)
+		}
+		return jar.Version
+	}
+	switch {
+	case change.Old == nil:
+		return "(missing) -> " + jarString(change.New)
+	case change.New == nil:
+		return jarString(change.Old) + " -> (removed)"
+	case change.Old.Type == "" && change.Old.Version == change.New.Version && change.Old.Checksum != change.New.Checksum:
+		return fmt.Sprintf("%s (%s -> %s)", change.New.Version,
+			utils.ShortHash(change.Old.Checksum), utils.ShortHash(change.New.Checksum))
+	}
+	return jarString(change.Old) + " -> " + jarString(change.New)
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mythicmc/peridot/config"
+)
+
+func TestLockUpdateTargets(t *testing.T) {
+	hub := config.Config{Plugins: []config.PluginRequirement{{Name: "LuckPerms"}, {Name: "Citizens"}}}
+	lobby := config.Config{Plugins: []config.PluginRequirement{{Name: "LuckPerms"}}}
+	tests := []struct {
+		name     string
+		server   string
+		updated  config.Configs
+		plugins  []string
+		software bool
+		want     []string
+	}{
+		{"lock keeps every server", "hub", config.Configs{}, nil, false, nil},
+		{"update of every plugin", "hub", config.Configs{"hub": hub}, nil, true, []string{"LuckPerms", "Citizens"}},
+		{"update of one plugin", "hub", config.Configs{"hub": hub}, []string{"Citizens"}, false, []string{"Citizens"}},
+		{"update of another server", "lobby", config.Configs{"hub": hub}, []string{"LuckPerms"}, false, nil},
+		{"update of selected servers", "lobby", config.Configs{"hub": hub, "lobby": lobby}, nil, true, []string{"LuckPerms"}},
+	}
+	for _, test := range tests {
+		software, plugins := lockUpdateTargets(test.server, test.updated[test.server], test.updated, test.plugins)
+		if software != test.software || !slices.Equal(plugins, test.want) {
+			t.Errorf("%s: got %t, %v, want %t, %v", test.name, software, plugins, test.software, test.want)
+		}
+	}
+}
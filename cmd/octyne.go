@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/mythicmc/peridot/config"
+	"github.com/mythicmc/peridot/utils"
+)
+
+var errStopTimeout = errors.New("timed out waiting for servers to stop")
+
+// octyneServer is a server managed by an Octyne instance.
+type octyneServer struct {
+	Client *utils.OctyneClient
+	Name   string // Name of the server in Octyne
+}
+
+// connectOctyne resolves the Octyne instance of each server from its config and the global settings.
+// Servers sharing the same Octyne instance and credentials share the same client.
+func connectOctyne(servers []string, configs config.Configs) (map[string]octyneServer, error) {
+	settings, err := config.LoadSettings()
+	if err != nil {
+		return nil, err
+	}
+	clients := make(map[utils.OctyneConfig]*utils.OctyneClient)
+	octyne := make(map[string]octyneServer, len(servers))
+	for _, server := range servers {
+		octyneConfig := configs[server].Octyne.Merge(settings.Octyne)
+		name := utils.PickNonEmptyString(octyneConfig.Server, server)
+		octyneConfig.Server = ""
+		client, ok := clients[octyneConfig]
+		if !ok {
+			client, err = utils.NewOctyneClient(octyneConfig)
+			if err != nil {
+				return nil, err
+			}
+			clients[octyneConfig] = client
+		}
+		octyne[server] = octyneServer{Client: client, Name: name}
+	}
+	return octyne, nil
+}
+
+// loadOctyneConfigs loads the current server configs for their Octyne settings, for commands which
+// don't otherwise need them. The given servers must still be configured, as stopping or starting
+// them with the default settings could affect another server.
+func loadOctyneConfigs(servers []string) (config.Configs, error) {
+	cache := loadJarCache(false)
+	defer cache.Save()
+	_, configs, err := loadReposConfigs(cache)
+	if err != nil {
+		return nil, err
+	}
+	for _, server := range servers {
+		if _, ok := configs[server]; !ok {
+			return nil, config.UnknownServerError{Name: server}
+		}
+	}
+	return configs, nil
+}
+
+// stopServers stops the given servers via Octyne and waits for them to stop.
+func stopServers(servers []string, octyne map[string]octyneServer) error {
+	// Send stop signals to all servers
+	fmt.Println("Stopping affected servers via Octyne...")
+	for _, server := range servers {
+		err := octyne[server].Client.TerminateServer(octyne[server].Name)
+		if err != nil {
+			log.Printf("Error stopping server %s: %v\n", server, err)
+			return err
+		}
+	}
+
+	// Wait for servers to stop
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			for {
+				<-time.After(2 * time.Second)
+				status, err := octyne[server].Client.GetServerStatus(octyne[server].Name)
+				if err != nil {
+					log.Printf("Error getting status for server %s: %v\n", server, err)
+					errs <- err
+					return
+				} else if status == utils.OctyneServerStatusStopped ||
+					status == utils.OctyneServerStatusCrashed {
+					errs <- nil
+					return
+				}
+			}
+		}()
+	}
+	timeout := time.After(60 * time.Second)
+	for range servers {
+		select {
+		case <-timeout:
+			log.Println("Failed to stop all servers after 60 seconds! Exiting...")
+			return errStopTimeout
+		case err := <-errs:
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// startServers sends start signals to the given servers via Octyne.
+func startServers(servers []string, octyne map[string]octyneServer) {
+	fmt.Println("Starting affected servers via Octyne...")
+	for _, server := range servers {
+		err := octyne[server].Client.StartServer(octyne[server].Name)
+		if err != nil {
+			log.Printf("Error starting server %s: %v\n", server, err)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"slices"
+
+	"github.com/mythicmc/peridot/config"
+	"github.com/mythicmc/peridot/deploy"
+)
+
+func HandlePlanCommand(fs *flag.FlagSet, args []string) int {
+	flags := addStateFlags(fs)
+	output := fs.String("o", "", "save the plan to this `file`, to be applied later with apply")
+	servers, err := parseFlags(fs, args)
+	if err != nil {
+		return parseErrorExitCode(err)
+	}
+
+	_, configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates, err :=
+		loadReposConfigUpdateState(servers, flags)
+	if err != nil {
+		return ExitError
+	}
+	previewUpdates(softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates)
+
+	if *output == "" {
+		return ExitOK
+	}
+	plan, err := deploy.NewPlan(
+		configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates)
+	if err != nil {
+		log.Println("An error has occurred while creating plan:", err)
+		return ExitError
+	}
+	if err := deploy.SavePlan(*output, plan); err != nil {
+		log.Println("An error has occurred while saving plan:", err)
+		return ExitError
+	}
+	fmt.Println("==============================")
+	fmt.Println("Plan saved to " + *output + ", apply it with: apply -plan " + *output)
+	return ExitOK
+}
+
+var errSelectionWithPlan = errors.New("server selection cannot be used with a saved plan")
+
+var errRotationWithPlan = errors.New("forwarding secrets cannot be rotated when applying a saved plan")
+
+// loadPlanUpdateState loads a saved plan and verifies it can still be applied.
+func loadPlanUpdateState(path string) (
+	config.Configs,
+	map[string]deploy.SoftwareUpdateOperation,
+	map[string][]deploy.ServerPropertiesUpdateOperation,
+	map[string]map[string]deploy.PluginUpdateOperation,
+	map[string][]deploy.ConfigFileUpdateOperation,
+	error,
+) {
+	plan, err := deploy.LoadPlan(path)
+	if err != nil {
+		log.Println("An error has occurred while loading plan:", err)
+		return nil, nil, nil, nil, nil, err
+	}
+	if err := plan.Verify(); err != nil {
+		log.Println("Refusing to apply plan:", err)
+		log.Println("Create a new plan with the plan command.")
+		return nil, nil, nil, nil, nil, err
+	}
+	// The plan only stores server locations, take the Octyne settings from the current configs, as
+	// well as the content of sensitive config files, which isn't saved in plans
+	currentConfigs, err := loadOctyneConfigs(slices.Sorted(maps.Keys(plan.Servers)))
+	if err != nil {
+		log.Println("An error has occurred while loading Octyne settings:", err)
+		return nil, nil, nil, nil, nil, err
+	} else if plan.HasSensitiveContent() {
+		if err := deploy.ResolveForwardingSecrets(currentConfigs, false); err != nil {
+			log.Println("An error has occurred while loading forwarding secrets:", err)
+			return nil, nil, nil, nil, nil, err
+		} else if err := plan.ResolveSensitiveContent(currentConfigs); err != nil {
+			log.Println("Refusing to apply plan:", err)
+			log.Println("Create a new plan with the plan command.")
+			return nil, nil, nil, nil, nil, err
+		}
+	}
+	configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates := plan.Updates()
+	for server, config := range configs {
+		config.Octyne = currentConfigs[server].Octyne
+		configs[server] = config
+	}
+	return configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates, nil
+}
+
+// loadApplyUpdateState loads the updates to apply from either a saved plan, if planFile is set, or
+// the current state of the selected servers.
+func loadApplyUpdateState(servers []string, planFile string, flags *stateFlags) (
+	config.Configs,
+	map[string]deploy.SoftwareUpdateOperation,
+	map[string][]deploy.ServerPropertiesUpdateOperation,
+	map[string]map[string]deploy.PluginUpdateOperation,
+	map[string][]deploy.ConfigFileUpdateOperation,
+	error,
+) {
+	if planFile != "" {
+		if len(servers) > 0 || len(flags.Tags) > 0 {
+			log.Println("An error has occurred while loading plan:", errSelectionWithPlan)
+			return nil, nil, nil, nil, nil, errSelectionWithPlan
+		} else if flags.RotateForwardingSecret {
+			log.Println("An error has occurred while loading plan:", errRotationWithPlan)
+			return nil, nil, nil, nil, nil, errRotationWithPlan
+		}
+		return loadPlanUpdateState(planFile)
+	}
+	_, configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates, err :=
+		loadReposConfigUpdateState(servers, flags)
+	return configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates, err
+}
+
+// confirmApply asks the user whether to proceed, unless they already agreed with --yes.
+func confirmApply(prompt string, yes bool) bool {
+	if yes {
+		return true
+	}
+	fmt.Print(prompt + " [y/N] ")
+	var response string
+	fmt.Scanln(&response)
+	return response == "y" || response == "Y"
+}
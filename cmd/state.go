@@ -1,8 +1,15 @@
 package cmd
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/mythicmc/peridot/config"
 	"github.com/mythicmc/peridot/deploy"
@@ -10,57 +17,143 @@ import (
 	"github.com/mythicmc/peridot/utils"
 )
 
-func loadReposConfigUpdateState() (
+func loadReposConfigs(cache *utils.JarCache) (repos.Repositories, config.Configs, error) {
+	// Load repositories
+	repositories, err := repos.LoadRepositories(cache)
+	if err != nil {
+		log.Println("An error has occurred while loading repositories:", err)
+		return nil, nil, err
+	}
+	// Load configuration
+	configs, err := config.LoadConfigs(repositories)
+	if err != nil {
+		log.Println("An error has occurred while loading configuration:", err)
+		return nil, nil, err
+	}
+	return repositories, configs, nil
+}
+
+func loadReposConfigUpdateState(servers []string, flags *stateFlags) (
 	repos.Repositories,
 	config.Configs,
 	map[string]deploy.SoftwareUpdateOperation,
 	map[string][]deploy.ServerPropertiesUpdateOperation,
 	map[string]map[string]deploy.PluginUpdateOperation,
+	map[string][]deploy.ConfigFileUpdateOperation,
+	error,
 ) {
-	// Load repositories
-	repositories, err := repos.LoadRepositories()
+	// Load cache of JAR checksums and metadata
+	cache := loadJarCache(flags.NoCache)
+	defer func() {
+		if err := cache.Save(); err != nil {
+			log.Println("Warning: Failed to save JAR cache:", err)
+		}
+	}()
+	// Load repositories and configuration
+	repositories, configs, err := loadReposConfigs(cache)
 	if err != nil {
-		log.Fatalln("An error has occurred while loading repositories:", err)
+		return nil, nil, nil, nil, nil, nil, err
 	}
-	// Load configuration
-	configs, err := config.LoadConfigs(repositories)
+	// Resolve forwarding secrets, which are shared between proxies and their servers
+	if err := deploy.ResolveForwardingSecrets(configs, flags.Apply); err != nil {
+		log.Println("An error has occurred while loading forwarding secrets:", err)
+		return nil, nil, nil, nil, nil, nil, err
+	}
+	// Select the servers to operate on
+	selected, err := configs.Select(servers, flags.Tags)
 	if err != nil {
-		log.Fatalln("An error has occurred while loading configuration:", err)
+		log.Println("An error has occurred while selecting servers:", err)
+		return nil, nil, nil, nil, nil, nil, err
+	} else if flags.RotateForwardingSecret {
+		if err := deploy.RotateForwardingSecrets(configs, selected); err != nil {
+			log.Println("An error has occurred while rotating forwarding secrets:", err)
+			return nil, nil, nil, nil, nil, nil, err
+		}
+	}
+	configs = selected
+	// Deploy the JARs locked in peridot.lock instead of the newest ones, if it exists
+	lockfile, err := config.LoadLockfile()
+	if err != nil {
+		log.Println("An error has occurred while loading peridot.lock:", err)
+		return nil, nil, nil, nil, nil, nil, err
+	} else if lockfile != nil {
+		if err := config.ApplyLockfile(configs, repositories, lockfile); err != nil {
+			log.Println("An error has occurred while loading peridot.lock:", err)
+			log.Println("Run 'peridot lock' to lock the current config.")
+			return nil, nil, nil, nil, nil, nil, err
+		}
 	}
 
 	// Prepare changes to software
-	softwareUpdates, err := deploy.PrepareAllSoftwareUpdates(repositories, configs)
+	prepareErrors := make(deploy.PrepareErrors)
+	softwareUpdates, err := deploy.PrepareAllSoftwareUpdates(repositories, configs, flags.Parallelism, cache)
 	if err != nil {
-		log.Println("An error has occurred while preparing software updates:", err,
-			"Continuing without software updates...")
+		log.Println("An error has occurred while preparing software updates:", err)
+		prepareErrors.Add(err)
 	}
 	// Prepare changes to server.properties
-	serverPropertiesUpdates, err := deploy.PrepareAllServerPropertiesUpdates(configs)
+	serverPropertiesUpdates, err := deploy.PrepareAllServerPropertiesUpdates(configs, flags.Parallelism)
 	if err != nil {
-		log.Println("An error has occurred while preparing server properties updates:", err,
-			"Continuing without server properties updates...")
+		log.Println("An error has occurred while preparing server properties updates:", err)
+		prepareErrors.Add(err)
 	}
 	// Prepare changes to plugins
-	pluginUpdates, err := deploy.PrepareAllPluginUpdates(repositories, configs)
+	pluginUpdates, err := deploy.PrepareAllPluginUpdates(repositories, configs, flags.Parallelism, cache)
+	if err != nil {
+		log.Println("An error has occurred while preparing plugin updates:", err)
+		prepareErrors.Add(err)
+	}
+	// Prepare changes to config files
+	configFileUpdates, err := deploy.PrepareAllConfigFileUpdates(configs, flags.Parallelism)
 	if err != nil {
-		log.Println("An error has occurred while preparing plugin updates:", err,
-			"Continuing without plugin updates...")
+		log.Println("An error has occurred while preparing config file updates:", err)
+		prepareErrors.Add(err)
+	}
+
+	// The updates of the other servers are still returned, for status to report them
+	if len(prepareErrors) > 0 {
+		return repositories, configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates,
+			prepareErrors
 	}
+	return repositories, configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates, nil
+}
 
-	return repositories, configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates
+// loadJarCache loads the JAR cache from the cache/ folder, or returns a nil cache if disabled.
+func loadJarCache(noCache bool) *utils.JarCache {
+	if noCache {
+		return nil
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		log.Println("Warning: Failed to load JAR cache, continuing without it:", err)
+		return nil
+	}
+	cache, err := utils.LoadJarCache(filepath.Join(wd, "cache", "jars.json"))
+	if err != nil {
+		log.Println("Warning: Failed to load JAR cache, continuing without it:", err)
+		return nil
+	}
+	return cache
 }
 
 func previewUpdates(
 	softwareUpdates map[string]deploy.SoftwareUpdateOperation,
 	serverPropertiesUpdates map[string][]deploy.ServerPropertiesUpdateOperation,
 	pluginUpdates map[string]map[string]deploy.PluginUpdateOperation,
+	configFileUpdates map[string][]deploy.ConfigFileUpdateOperation,
 ) {
 	if len(softwareUpdates) > 0 {
 		fmt.Println("Pending software updates:")
-		for server, update := range softwareUpdates {
-			prevHash := utils.PickNonEmptyString(update.PrevHash, "(missing)")
-			newHash := utils.PickNonEmptyString(update.NewHash, "(removed)")
-			fmt.Printf(" - %s: %s (%s -> %s)\n", server, update.SoftwareType, prevHash, newHash)
+		for _, server := range slices.Sorted(maps.Keys(softwareUpdates)) {
+			update := softwareUpdates[server]
+			prevHash := utils.PickNonEmptyString(utils.ShortHash(update.PrevHash), "(missing)")
+			newHash := utils.PickNonEmptyString(utils.ShortHash(update.NewHash), "(removed)")
+			if update.NewServer {
+				fmt.Printf(" - %s: %s (%s -> %s) (new server at %s)\n", server, update.SoftwareType,
+					prevHash, newHash, filepath.Dir(update.CurrentPath))
+			} else {
+				fmt.Printf(" - %s: %s (%s -> %s)\n", server, update.SoftwareType, prevHash, newHash)
+			}
 		}
 	} else {
 		fmt.Println("Software: Up to date")
@@ -68,11 +161,16 @@ func previewUpdates(
 	fmt.Println("==============================")
 	if len(serverPropertiesUpdates) > 0 {
 		fmt.Println("Pending server properties updates:")
-		for server, updates := range serverPropertiesUpdates {
+		for _, server := range slices.Sorted(maps.Keys(serverPropertiesUpdates)) {
 			fmt.Println(" - " + server)
-			for _, update := range updates {
-				oldValue := utils.PickNonEmptyString(update.OldValue, "(missing)")
-				newValue := utils.PickNonEmptyString(update.NewValue, "(removed)")
+			for _, update := range serverPropertiesUpdates[server] {
+				oldValue, newValue := update.OldValue, update.NewValue
+				if update.Added {
+					oldValue = "(missing)"
+				}
+				if update.Removed {
+					newValue = "(removed)"
+				}
 				fmt.Printf("\t=> %s: %s -> %s\n", update.Property, oldValue, newValue)
 			}
 		}
@@ -82,20 +180,96 @@ func previewUpdates(
 	fmt.Println("==============================")
 	if len(pluginUpdates) > 0 {
 		fmt.Println("Pending plugin updates:")
-		for server, updates := range pluginUpdates {
+		for _, server := range slices.Sorted(maps.Keys(pluginUpdates)) {
 			fmt.Println(" -> " + server)
-			for _, update := range updates {
+			for _, name := range slices.Sorted(maps.Keys(pluginUpdates[server])) {
+				update := pluginUpdates[server][name]
 				prevVersion := utils.PickNonEmptyString(update.PrevVersion, "(missing)")
 				newVersion := utils.PickNonEmptyString(update.NewVersion, "(removed)")
-				fmt.Printf("    => %s: %s -> %s\n", update.PluginName, prevVersion, newVersion)
+				flags := ""
+				if update.PrevVersion != "" && update.NewVersion != "" &&
+					utils.CompareVersions(update.NewVersion, update.PrevVersion) < 0 {
+					flags += " (downgrade!)"
+				}
+				if update.RequiresMinecraft != "" {
+					flags += " (requires Minecraft " + update.RequiresMinecraft + "!)"
+				}
+				fmt.Printf("    => %s: %s -> %s%s\n", update.PluginName, prevVersion, newVersion, flags)
 			}
 		}
 	} else {
 		fmt.Println("Plugins: Up to date")
 	}
+	fmt.Println("==============================")
+	previewConfigFileUpdates(configFileUpdates)
+}
+
+func previewConfigFileUpdates(configFileUpdates map[string][]deploy.ConfigFileUpdateOperation) {
+	if len(configFileUpdates) > 0 {
+		fmt.Println("Pending config file updates:")
+		for _, server := range slices.Sorted(maps.Keys(configFileUpdates)) {
+			fmt.Println(" - " + server)
+			for _, update := range configFileUpdates[server] {
+				if update.PrevChecksum == "" {
+					fmt.Printf("\t=> %s: (created)\n", update.Name)
+				} else if len(update.Changes) == 0 {
+					fmt.Printf("\t=> %s: (replaced)\n", update.Name)
+				} else {
+					fmt.Printf("\t=> %s:\n", update.Name)
+				}
+				for _, change := range update.Changes {
+					oldValue := utils.PickNonEmptyString(change.OldValue, "(missing)")
+					newValue := utils.PickNonEmptyString(change.NewValue, "(removed)")
+					fmt.Printf("\t\t%s: %s -> %s\n", change.Key, oldValue, newValue)
+				}
+			}
+		}
+	} else {
+		fmt.Println("Config files: Up to date")
+	}
 }
 
-func HandleStateCommand(args []string) {
-	_, _, softwareUpdates, serverPropertiesUpdates, pluginUpdates := loadReposConfigUpdateState()
-	previewUpdates(softwareUpdates, serverPropertiesUpdates, pluginUpdates)
+func HandleStateCommand(fs *flag.FlagSet, args []string) int {
+	flags := addStateFlags(fs)
+	output := fs.String("output", "text", "output `format`, one of: text, json, ndjson")
+	servers, err := parseFlags(fs, args)
+	if err != nil {
+		return parseErrorExitCode(err)
+	} else if *output != "text" && *output != "json" && *output != "ndjson" {
+		fmt.Fprintln(fs.Output(), "invalid output format: "+*output)
+		fs.Usage()
+		return ExitUsage
+	}
+
+	// Servers whose updates failed to be prepared are reported as errored, along with the others
+	_, configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates, err :=
+		loadReposConfigUpdateState(servers, flags)
+	var prepareErrors deploy.PrepareErrors
+	if err != nil && !errors.As(err, &prepareErrors) {
+		return ExitError
+	}
+	report := buildStatusReport(configs,
+		softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates, prepareErrors)
+	var writeErr error
+	switch *output {
+	case "json":
+		writeErr = writeStatusJSON(os.Stdout, report)
+	case "ndjson":
+		writeErr = writeStatusNDJSON(os.Stdout, report)
+	default:
+		previewUpdates(softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates)
+		if len(prepareErrors) > 0 {
+			fmt.Println("==============================")
+			fmt.Println("Failed to prepare updates for: " + strings.Join(slices.Sorted(maps.Keys(prepareErrors)), ", "))
+		}
+	}
+	if writeErr != nil {
+		log.Println("An error has occurred while writing status:", writeErr)
+		return ExitError
+	} else if len(prepareErrors) > 0 {
+		return ExitError
+	} else if report.HasDrift() {
+		return ExitDrift
+	}
+	return ExitOK
 }
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/mythicmc/peridot/config"
+	"github.com/mythicmc/peridot/deploy"
+	"github.com/mythicmc/peridot/utils"
+)
+
+// The JSON status schema is consumed by external tooling, only add fields to it.
+
+type statusReport struct {
+	Servers []serverStatus `json:"servers"`
+}
+
+type serverStatus struct {
+	Server           string             `json:"server"`
+	Drift            bool               `json:"drift"`
+	NewServer        bool               `json:"new_server"` // The server's location doesn't exist yet
+	Error            string             `json:"error"`      // Empty unless its updates failed to be prepared
+	Software         *softwareStatus    `json:"software"`   // Null if up to date
+	ServerProperties []propertyStatus   `json:"server_properties"`
+	Plugins          []pluginStatus     `json:"plugins"`
+	ConfigFiles      []configFileStatus `json:"config_files"`
+}
+
+type softwareStatus struct {
+	Type        string `json:"type"`
+	CurrentHash string `json:"current_hash"` // Empty if missing
+	DesiredHash string `json:"desired_hash"`
+}
+
+type propertyStatus struct {
+	Property     string `json:"property"`
+	Action       string `json:"action"`        // "add", "update" or "remove"
+	CurrentValue string `json:"current_value"` // Empty if missing
+	DesiredValue string `json:"desired_value"` // Empty if removed
+}
+
+type pluginStatus struct {
+	Name           string `json:"name"`
+	Action         string `json:"action"`          // "add", "update" or "remove"
+	CurrentVersion string `json:"current_version"` // Empty if missing
+	DesiredVersion string `json:"desired_version"` // Empty if removed
+	Downgrade      bool   `json:"downgrade"`       // The desired version is older than the current one
+	Incompatible   bool   `json:"incompatible"`    // The desired version requires a newer Minecraft version
+	// Minecraft version required by the api-version of the desired version, empty unless incompatible
+	RequiresMinecraft string `json:"requires_minecraft"`
+}
+
+type configFileStatus struct {
+	Path    string               `json:"path"`    // Relative to the server location
+	Action  string               `json:"action"`  // "create" or "update"
+	Changes []configChangeStatus `json:"changes"` // Empty if the whole file is replaced
+}
+
+type configChangeStatus struct {
+	Key          string `json:"key"`
+	CurrentValue string `json:"current_value"` // Empty if missing
+	DesiredValue string `json:"desired_value"` // Empty if removed
+}
+
+func buildStatusReport(
+	configs config.Configs,
+	softwareUpdates map[string]deploy.SoftwareUpdateOperation,
+	serverPropertiesUpdates map[string][]deploy.ServerPropertiesUpdateOperation,
+	pluginUpdates map[string]map[string]deploy.PluginUpdateOperation,
+	configFileUpdates map[string][]deploy.ConfigFileUpdateOperation,
+	prepareErrors deploy.PrepareErrors,
+) statusReport {
+	servers := make([]string, 0, len(configs))
+	for server := range configs {
+		servers = append(servers, server)
+	}
+	slices.Sort(servers)
+
+	report := statusReport{Servers: make([]serverStatus, 0, len(servers))}
+	for _, server := range servers {
+		status := serverStatus{
+			Server:           server,
+			ServerProperties: make([]propertyStatus, 0),
+			Plugins:          make([]pluginStatus, 0),
+			ConfigFiles:      make([]configFileStatus, 0),
+		}
+		if err, ok := prepareErrors[server]; ok {
+			status.Error = strings.ReplaceAll(err.Error(), "\n", ": ")
+		}
+		if update, ok := softwareUpdates[server]; ok {
+			status.NewServer = update.NewServer
+			status.Software = &softwareStatus{
+				Type:        update.SoftwareType,
+				CurrentHash: update.PrevHash,
+				DesiredHash: update.NewHash,
+			}
+		}
+		for _, update := range serverPropertiesUpdates[server] {
+			action := "update"
+			if update.Removed {
+				action = "remove"
+			} else if update.Added {
+				action = "add"
+			}
+			status.ServerProperties = append(status.ServerProperties, propertyStatus{
+				Property:     update.Property,
+				Action:       action,
+				CurrentValue: update.OldValue,
+				DesiredValue: update.NewValue,
+			})
+		}
+		slices.SortFunc(status.ServerProperties, func(a, b propertyStatus) int {
+			return strings.Compare(a.Property, b.Property)
+		})
+		for _, update := range pluginUpdates[server] {
+			action := "update"
+			if update.UpdatePath == "" {
+				action = "remove"
+			} else if update.PrevVersion == "" {
+				action = "add"
+			}
+			status.Plugins = append(status.Plugins, pluginStatus{
+				Name:           update.PluginName,
+				Action:         action,
+				CurrentVersion: update.PrevVersion,
+				DesiredVersion: update.NewVersion,
+				Downgrade: action == "update" &&
+					utils.CompareVersions(update.NewVersion, update.PrevVersion) < 0,
+				Incompatible:      update.RequiresMinecraft != "",
+				RequiresMinecraft: update.RequiresMinecraft,
+			})
+		}
+		slices.SortFunc(status.Plugins, func(a, b pluginStatus) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+		for _, update := range configFileUpdates[server] {
+			fileStatus := configFileStatus{Path: update.Name, Action: "update", Changes: make([]configChangeStatus, 0)}
+			if update.PrevChecksum == "" {
+				fileStatus.Action = "create"
+			}
+			for _, change := range update.Changes {
+				fileStatus.Changes = append(fileStatus.Changes, configChangeStatus{
+					Key:          change.Key,
+					CurrentValue: change.OldValue,
+					DesiredValue: change.NewValue,
+				})
+			}
+			status.ConfigFiles = append(status.ConfigFiles, fileStatus)
+		}
+		status.Drift = status.Software != nil || len(status.ServerProperties) > 0 || len(status.Plugins) > 0 ||
+			len(status.ConfigFiles) > 0
+		report.Servers = append(report.Servers, status)
+	}
+	return report
+}
+
+func (r statusReport) HasDrift() bool {
+	return slices.ContainsFunc(r.Servers, func(status serverStatus) bool { return status.Drift })
+}
+
+func writeStatusJSON(out io.Writer, report statusReport) error {
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func writeStatusNDJSON(out io.Writer, report statusReport) error {
+	encoder := json.NewEncoder(out)
+	for _, status := range report.Servers {
+		if err := encoder.Encode(status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
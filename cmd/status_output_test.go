@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mythicmc/peridot/config"
+	"github.com/mythicmc/peridot/deploy"
+	"github.com/mythicmc/peridot/utils"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata/")
+
+// testStatusReport returns a report of a server with every kind of difference, an up to date server,
+// a new server and a server whose updates failed to be prepared.
+func testStatusReport() statusReport {
+	configs := config.Configs{"broken": {}, "hub": {}, "lobby": {}, "survival": {}}
+	softwareUpdates := map[string]deploy.SoftwareUpdateOperation{
+		"hub":      {SoftwareType: "paper", PrevHash: "paper-old", NewHash: "paper-new"},
+		"survival": {SoftwareType: "paper", NewHash: "paper-new", NewServer: true},
+	}
+	serverPropertiesUpdates := map[string][]deploy.ServerPropertiesUpdateOperation{
+		"hub": {
+			{Property: "server-port", OldValue: "25565", NewValue: "25566"},
+			{Property: "motd", NewValue: "§aWelcome!", Added: true},
+			{Property: "level-seed", OldValue: "1234", Removed: true},
+		},
+		"survival": {{Property: "server-port", NewValue: "25567", Added: true}},
+	}
+	pluginUpdates := map[string]map[string]deploy.PluginUpdateOperation{
+		"hub": {
+			"LuckPerms":  {PluginName: "LuckPerms", UpdatePath: "LuckPerms.jar", PrevVersion: "5.4.100", NewVersion: "5.4.102"},
+			"Citizens":   {PluginName: "Citizens", UpdatePath: "Citizens.jar", PrevVersion: "2.0.35", NewVersion: "2.0.33"},
+			"Sentinel":   {PluginName: "Sentinel", UpdatePath: "Sentinel.jar", NewVersion: "2.9.0"},
+			"Essentials": {PluginName: "Essentials", CurrentPath: "Essentials.jar", PrevVersion: "2.20.1"},
+			"New":        {PluginName: "New", UpdatePath: "New.jar", NewVersion: "1.0", RequiresMinecraft: "1.21"},
+		},
+	}
+	configFileUpdates := map[string][]deploy.ConfigFileUpdateOperation{
+		"hub": {
+			{Name: "bukkit.yml", PrevChecksum: "old", NewChecksum: "new", Changes: []utils.ConfigChange{
+				{Key: "settings.allow-end", OldValue: "true", NewValue: "false"},
+				{Key: "settings.shutdown-message", OldValue: "Server closed"},
+			}},
+			{Name: "plugins/LuckPerms/config.yml", NewChecksum: "new"},
+		},
+	}
+	prepareErrors := deploy.PrepareErrors{
+		"broken": errors.New("failed to read plugins folder\nopen /srv/broken/plugins: permission denied"),
+	}
+	return buildStatusReport(
+		configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates, prepareErrors,
+	)
+}
+
+func TestStatusOutput(t *testing.T) {
+	tests := []struct {
+		golden string
+		write  func(out *bytes.Buffer, report statusReport) error
+	}{
+		{"status.json", func(out *bytes.Buffer, report statusReport) error { return writeStatusJSON(out, report) }},
+		{"status.ndjson", func(out *bytes.Buffer, report statusReport) error { return writeStatusNDJSON(out, report) }},
+	}
+	report := testStatusReport()
+	if !report.HasDrift() {
+		t.Error("HasDrift() = false, want true")
+	}
+	for _, test := range tests {
+		var out bytes.Buffer
+		if err := test.write(&out, report); err != nil {
+			t.Errorf("%s: %v", test.golden, err)
+			continue
+		}
+		path := filepath.Join("testdata", test.golden)
+		if *updateGolden {
+			if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		} else if out.String() != string(want) {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.golden, out.String(), want)
+		}
+	}
+}
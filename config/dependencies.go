@@ -0,0 +1,113 @@
+package config
+
+import (
+	"maps"
+	"slices"
+
+	"github.com/mythicmc/peridot/repos"
+)
+
+type MissingPluginDependencyError struct{ Plugin, Dependency string }
+
+func (e MissingPluginDependencyError) Error() string {
+	return "plugin " + e.Plugin + " depends on " + e.Dependency + ", which is missing from the plugins list"
+}
+
+// validateConfigDependencies checks that the hard dependencies of every plugin are in the plugins
+// list, as the server would refuse to load the plugin otherwise.
+func validateConfigDependencies(config Config, repositories repos.Repositories) error {
+	plugins := resolveConfigPlugins(config, repositories)
+	for _, plugin := range plugins {
+		for _, dependency := range plugin.MetadataFor(config.Software).Depend {
+			if !providesDependency(plugins, dependency, config.Software) {
+				return MissingPluginDependencyError{Plugin: plugin.Name, Dependency: dependency}
+			}
+		}
+	}
+	return nil
+}
+
+// includeDependencies adds the missing hard dependencies of the plugins to the plugins list,
+// including the dependencies of those dependencies. Dependencies which can't be found in the
+// configured repositories are left out, for validation to report them.
+func includeDependencies(config Config, repositories repos.Repositories) Config {
+	plugins := resolveConfigPlugins(config, repositories)
+	config.Plugins = slices.Clone(config.Plugins)
+	for i := 0; i < len(plugins); i++ {
+		for _, dependency := range plugins[i].MetadataFor(config.Software).Depend {
+			if providesDependency(plugins, dependency, config.Software) {
+				continue
+			} else if plugin, ok := findDependency(dependency, config, repositories); ok {
+				config.Plugins = append(config.Plugins, PluginRequirement{Name: plugin.Name})
+				plugins = append(plugins, plugin)
+			}
+		}
+	}
+	return config
+}
+
+// resolveConfigPlugins returns the plugins deployed for a config, skipping invalid requirements.
+// Plugins are resolved to their locked JARs while these still satisfy the config.
+func resolveConfigPlugins(config Config, repositories repos.Repositories) []repos.Plugin {
+	plugins := make([]repos.Plugin, 0, len(config.Plugins))
+	for _, requirement := range config.Plugins {
+		if plugin, ok := findLockedPlugin(requirement, config, repositories); ok {
+			plugins = append(plugins, plugin)
+			continue
+		}
+		constraint, err := requirement.Constraint()
+		if err != nil {
+			continue
+		}
+		if plugin, err := repositories.FindPlugin(requirement.Name, constraint, config.Repos); err == nil {
+			plugins = append(plugins, plugin)
+		}
+	}
+	return plugins
+}
+
+// findLockedPlugin returns the JAR a plugin is locked to, if the config is locked and the locked JAR
+// still satisfies the requirement.
+func findLockedPlugin(
+	requirement PluginRequirement, config Config, repositories repos.Repositories,
+) (repos.Plugin, bool) {
+	if config.Lock == nil || config.Lock.pluginOutdatedReason(requirement, config, repositories) != "" {
+		return repos.Plugin{}, false
+	}
+	jar := config.Lock.Plugins[requirement.Name]
+	return repositories.FindPluginChecksum(requirement.Name, jar.Checksum, jar.Repo)
+}
+
+// providesDependency checks if any of the plugins provides a dependency on the platform loaded by
+// the software.
+func providesDependency(plugins []repos.Plugin, dependency string, software string) bool {
+	return slices.ContainsFunc(plugins, func(plugin repos.Plugin) bool {
+		return plugin.MetadataFor(software).Provides(dependency)
+	})
+}
+
+// findDependency returns the plugin providing a dependency, either by name or by plugin ID. Plugins
+// locked for the config are preferred, then the newest plugin from the last of the config's
+// repositories which has it.
+func findDependency(dependency string, config Config, repositories repos.Repositories) (repos.Plugin, bool) {
+	if config.Lock != nil {
+		for _, name := range slices.Sorted(maps.Keys(config.Lock.Plugins)) {
+			plugin, ok := findLockedPlugin(PluginRequirement{Name: name}, config, repositories)
+			if ok && plugin.MetadataFor(config.Software).Provides(dependency) {
+				return plugin, true
+			}
+		}
+	}
+	if plugin, err := repositories.FindPlugin(dependency, nil, config.Repos); err == nil {
+		return plugin, true
+	}
+	for _, repoName := range slices.Backward(config.Repos) {
+		plugins := repositories[repoName].Plugins
+		for _, name := range slices.Sorted(maps.Keys(plugins)) {
+			if plugins[name].MetadataFor(config.Software).Provides(dependency) {
+				return plugins[name], true
+			}
+		}
+	}
+	return repos.Plugin{}, false
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mythicmc/peridot/repos"
+	"github.com/mythicmc/peridot/utils"
+)
+
+// testDependencyRepositories returns a repository where Shop 2.0 depends on Economy, which depends on
+// Vault, provided by VaultUnlocked through its plugin ID. The older Shop 1.0 depends on Vault directly.
+func testDependencyRepositories() repos.Repositories {
+	plugin := func(name, id, version string, depend ...string) repos.Plugin {
+		metadata := utils.PluginMetadata{Platform: "bukkit", ID: id, Name: name, Version: version, Depend: depend}
+		return repos.Plugin{
+			Repo: "main", Name: name, Path: "/repos/main/" + name + "-" + version + ".jar", Version: version,
+			Checksum: strings.ToLower(name) + "-" + version, Metadata: metadata,
+			Platforms: []utils.PluginMetadata{metadata},
+		}
+	}
+	pluginVersions := map[string][]repos.Plugin{
+		"Shop":          {plugin("Shop", "Shop", "2.0", "Economy"), plugin("Shop", "Shop", "1.0", "Vault")},
+		"Economy":       {plugin("Economy", "Economy", "1.0", "Vault")},
+		"VaultUnlocked": {plugin("VaultUnlocked", "Vault", "2.0")},
+		"Broken":        {plugin("Broken", "Broken", "1.0", "Missing")},
+	}
+	paper := repos.Software{Repo: "main", Type: "paper", Checksum: "paper", Version: "1.20.4"}
+	repo := repos.Repository{
+		Name:             "main",
+		Software:         map[string]repos.Software{"paper": paper},
+		Plugins:          make(map[string]repos.Plugin),
+		SoftwareVersions: map[string][]repos.Software{"paper": {paper}},
+		PluginVersions:   pluginVersions,
+	}
+	for name, versions := range pluginVersions {
+		repo.Plugins[name] = versions[0]
+	}
+	return repos.Repositories{"main": repo}
+}
+
+// testDependencyConfig returns a config with the given plugins, locked to Shop 1.0 if locked is set.
+func testDependencyConfig(locked bool, plugins ...string) Config {
+	config := Config{Repos: []string{"main"}, Software: "paper"}
+	for _, name := range plugins {
+		config.Plugins = append(config.Plugins, PluginRequirement{Name: name})
+	}
+	if locked {
+		config.Lock = &LockedServer{
+			Software: LockedJar{Type: "paper", Checksum: "paper", Repo: "main"},
+			Plugins:  map[string]LockedJar{"Shop": {Version: "1.0", Checksum: "shop-1.0", Repo: "main"}},
+		}
+	}
+	return config
+}
+
+func TestIncludeDependencies(t *testing.T) {
+	repositories := testDependencyRepositories()
+	tests := []struct {
+		name   string
+		config Config
+		want   []string
+	}{
+		{"transitive", testDependencyConfig(false, "Shop"), []string{"Shop", "Economy", "VaultUnlocked"}},
+		{
+			"already listed",
+			testDependencyConfig(false, "VaultUnlocked", "Economy", "Shop"),
+			[]string{"VaultUnlocked", "Economy", "Shop"},
+		},
+		{"provided by plugin ID", testDependencyConfig(false, "Economy"), []string{"Economy", "VaultUnlocked"}},
+		{"locked older version", testDependencyConfig(true, "Shop"), []string{"Shop", "VaultUnlocked"}},
+		{"missing dependency left out", testDependencyConfig(false, "Broken"), []string{"Broken"}},
+	}
+	for _, test := range tests {
+		config := includeDependencies(test.config, repositories)
+		got := make([]string, 0, len(config.Plugins))
+		for _, plugin := range config.Plugins {
+			got = append(got, plugin.Name)
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestValidateConfigDependencies(t *testing.T) {
+	repositories := testDependencyRepositories()
+	tests := []struct {
+		name   string
+		config Config
+		err    error
+	}{
+		{"all listed", testDependencyConfig(false, "Shop", "Economy", "VaultUnlocked"), nil},
+		{"provided by plugin ID", testDependencyConfig(false, "Economy", "VaultUnlocked"), nil},
+		{
+			"missing dependency",
+			testDependencyConfig(false, "Shop", "VaultUnlocked"),
+			MissingPluginDependencyError{Plugin: "Shop", Dependency: "Economy"},
+		},
+		{
+			"missing transitive dependency",
+			testDependencyConfig(false, "Shop", "Economy"),
+			MissingPluginDependencyError{Plugin: "Economy", Dependency: "Vault"},
+		},
+		{"locked older version", testDependencyConfig(true, "Shop", "VaultUnlocked"), nil},
+		{
+			"locked older version missing dependency",
+			testDependencyConfig(true, "Shop"),
+			MissingPluginDependencyError{Plugin: "Shop", Dependency: "Vault"},
+		},
+	}
+	for _, test := range tests {
+		if err := validateConfigDependencies(test.config, repositories); err != test.err {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mythicmc/peridot/repos"
+	"github.com/mythicmc/peridot/utils"
+)
+
+// ImportedServer is the state of an existing server, inspected to generate a config for it.
+type ImportedServer struct {
+	Location         string
+	Software         *ImportedJar // Nil if no server software was found
+	Plugins          []ImportedJar
+	ServerProperties map[string]string // Nil if the server has no server.properties
+	Eula             bool
+	Repos            []string // Repositories selected to provide the software and plugins
+}
+
+// ImportedJar is a software or plugin JAR found in an existing server.
+type ImportedJar struct {
+	Name     string // Plugin name, or software type
+	Version  string // Empty for software
+	Path     string
+	Checksum string
+	Repos    []string // Repositories with an identical JAR
+	Others   []string // Repositories with a different version of this plugin or software
+	Pinned   bool     // Newer versions are in the selected repositories, so this one is pinned
+}
+
+var ErrImportNotFolder = errors.New("server location is not a folder")
+
+// InspectServer inspects the software, plugins and server.properties of an existing server, and
+// selects the repositories providing identical JARs, preferring the fewest repositories possible.
+func InspectServer(
+	location string, repositories repos.Repositories, cache *utils.JarCache,
+) (ImportedServer, error) {
+	location, err := filepath.Abs(location)
+	if err != nil {
+		return ImportedServer{}, err
+	} else if stat, err := os.Stat(location); err != nil {
+		return ImportedServer{}, err
+	} else if !stat.IsDir() {
+		return ImportedServer{}, ErrImportNotFolder
+	}
+	server := ImportedServer{Location: location}
+
+	software, err := inspectJars(location, cache)
+	if err != nil {
+		return ImportedServer{}, err
+	}
+	for _, jar := range software {
+		if jar.Type == "plugin" {
+			continue
+		} else if server.Software != nil {
+			log.Printf("Warning: Found multiple server software JARs, ignoring %s...\n", jar.Path)
+			continue
+		}
+		server.Software = &ImportedJar{Name: jar.Type, Path: jar.Path, Checksum: jar.Checksum}
+		for _, name := range slices.Sorted(maps.Keys(repositories)) {
+			if versions := repositories[name].SoftwareVersions[jar.Type]; len(versions) == 0 {
+				continue
+			} else if slices.ContainsFunc(versions, func(software repos.Software) bool {
+				return software.Checksum == jar.Checksum
+			}) {
+				server.Software.Repos = append(server.Software.Repos, name)
+			} else {
+				server.Software.Others = append(server.Software.Others, name)
+			}
+		}
+	}
+
+	plugins, err := inspectJars(filepath.Join(location, "plugins"), cache)
+	if err != nil && !os.IsNotExist(err) {
+		return ImportedServer{}, err
+	}
+	softwareType := ""
+	if server.Software != nil {
+		softwareType = server.Software.Name
+	}
+	for _, jar := range plugins {
+		metadata, ok := jar.MetadataFor(softwareType)
+		if !ok {
+			log.Printf("Warning: %s is not a plugin JAR, skipping...\n", jar.Path)
+			continue
+		}
+		plugin := ImportedJar{
+			Name:     metadata.Name,
+			Version:  metadata.Version,
+			Path:     jar.Path,
+			Checksum: jar.Checksum,
+		}
+		for _, name := range slices.Sorted(maps.Keys(repositories)) {
+			if versions := repositories[name].PluginVersions[plugin.Name]; len(versions) == 0 {
+				continue
+			} else if slices.ContainsFunc(versions, func(repoPlugin repos.Plugin) bool {
+				return repoPlugin.Checksum == plugin.Checksum
+			}) {
+				plugin.Repos = append(plugin.Repos, name)
+			} else {
+				plugin.Others = append(plugin.Others, name)
+			}
+		}
+		server.Plugins = append(server.Plugins, plugin)
+	}
+	slices.SortFunc(server.Plugins, func(a, b ImportedJar) int { return strings.Compare(a.Name, b.Name) })
+
+	if server.Software != nil && server.Software.Name != "velocity" {
+		data, err := os.ReadFile(filepath.Join(location, "server.properties"))
+		if err != nil && !os.IsNotExist(err) {
+			return ImportedServer{}, err
+		} else if err == nil {
+			properties := utils.ParseProperties(data)
+			server.ServerProperties = make(map[string]string)
+			for _, key := range properties.Keys() {
+				server.ServerProperties[key], _ = properties.Get(key)
+			}
+		}
+		data, err = os.ReadFile(filepath.Join(location, "eula.txt"))
+		if err != nil && !os.IsNotExist(err) {
+			return ImportedServer{}, err
+		} else if value, _ := utils.ParseProperties(data).Get("eula"); value == "true" {
+			server.Eula = true
+		}
+	}
+
+	server.Repos = server.selectRepos()
+	server.pinOlderJars(repositories)
+	return server, nil
+}
+
+// pinOlderJars pins the JARs which are older than the newest ones in the selected repositories, so
+// that the config keeps them at their current version.
+func (s *ImportedServer) pinOlderJars(repositories repos.Repositories) {
+	if s.Software != nil && len(s.Software.Repos) > 0 {
+		software, ok := repositories.FindSoftware(s.Software.Name, "", s.Repos)
+		s.Software.Pinned = ok && software.Checksum != s.Software.Checksum
+	}
+	for i, plugin := range s.Plugins {
+		if len(plugin.Repos) > 0 {
+			newest, err := repositories.GetPlugin(plugin.Name, s.Repos)
+			s.Plugins[i].Pinned = err == nil && newest.Checksum != plugin.Checksum
+		}
+	}
+}
+
+type inspectedJar struct {
+	Path string
+	utils.JarInfo
+}
+
+// inspectJars inspects the JARs directly inside a folder, skipping JARs of unknown types.
+func inspectJars(folder string, cache *utils.JarCache) ([]inspectedJar, error) {
+	files, err := os.ReadDir(folder)
+	if err != nil {
+		return nil, err
+	}
+	jars := make([]inspectedJar, 0)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".jar") {
+			continue
+		}
+		path := filepath.Join(folder, file.Name())
+		jarInfo, err := cache.Inspect(path)
+		var metadataErr utils.JarMetadataError
+		if errors.Is(err, utils.ErrUnknownJarType) {
+			log.Printf("Warning: %s is not a recognized JAR type, skipping...\n", path)
+			continue
+		} else if errors.As(err, &metadataErr) {
+			log.Printf("Warning: Failed to load plugin metadata from %s, skipping: %v\n", path, metadataErr.Err)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		jars = append(jars, inspectedJar{Path: path, JarInfo: jarInfo})
+	}
+	return jars, nil
+}
+
+// Jars returns the software JAR, if any, followed by the plugin JARs.
+func (s ImportedServer) Jars() []ImportedJar {
+	jars := slices.Clone(s.Plugins)
+	if s.Software != nil {
+		jars = slices.Insert(jars, 0, *s.Software)
+	}
+	return jars
+}
+
+// UnknownJars returns the JARs which aren't in any of the selected repositories.
+func (s ImportedServer) UnknownJars() []ImportedJar {
+	return slices.DeleteFunc(s.Jars(), func(jar ImportedJar) bool {
+		return slices.ContainsFunc(jar.Repos, func(repo string) bool { return slices.Contains(s.Repos, repo) })
+	})
+}
+
+// AddRepo adds a repository containing the given JARs after the selected repositories, so that its
+// JARs take precedence.
+func (s *ImportedServer) AddRepo(name string, jars []ImportedJar) {
+	for _, jar := range jars {
+		if s.Software != nil && s.Software.Path == jar.Path {
+			s.Software.Repos = append(s.Software.Repos, name)
+		}
+		for i := range s.Plugins {
+			if s.Plugins[i].Path == jar.Path {
+				s.Plugins[i].Repos = append(s.Plugins[i].Repos, name)
+			}
+		}
+	}
+	s.Repos = append(s.Repos, name)
+}
+
+// selectRepos greedily selects the repositories providing the most JARs until every JAR found in
+// a repository is provided. The repository providing the most JARs is placed last, so its plugins
+// take precedence.
+func (s ImportedServer) selectRepos() []string {
+	selected := make([]string, 0)
+	remaining := slices.DeleteFunc(s.Jars(), func(jar ImportedJar) bool { return len(jar.Repos) == 0 })
+	for len(remaining) > 0 {
+		counts := make(map[string]int)
+		for _, jar := range remaining {
+			for _, repo := range jar.Repos {
+				counts[repo]++
+			}
+		}
+		best := ""
+		for _, repo := range slices.Sorted(maps.Keys(counts)) {
+			if best == "" || counts[repo] > counts[best] {
+				best = repo
+			}
+		}
+		selected = append(selected, best)
+		remaining = slices.DeleteFunc(remaining, func(jar ImportedJar) bool { return slices.Contains(jar.Repos, best) })
+	}
+	slices.Reverse(selected)
+	return selected
+}
+
+// GenerateConfigFile generates the contents of a config file describing an imported server. JARs
+// which aren't in the selected repositories are left commented out, with a note on where to get them.
+func GenerateConfigFile(server ImportedServer) string {
+	var builder strings.Builder
+	builder.WriteString("module.exports = {\n")
+	fmt.Fprintf(&builder, "  location: %s,\n", jsString(server.Location))
+	quotedRepos := make([]string, len(server.Repos))
+	for i, repo := range server.Repos {
+		quotedRepos[i] = jsString(repo)
+	}
+	fmt.Fprintf(&builder, "  repos: [%s],\n", strings.Join(quotedRepos, ", "))
+	if server.Software != nil {
+		software := jsString(server.Software.Name)
+		if server.Software.Pinned {
+			software = "{ type: " + software + ", checksum: " + jsString(server.Software.Checksum) + " }"
+		}
+		fmt.Fprintf(&builder, "  software: %s,%s\n", software, importComment(*server.Software, server.Repos))
+	} else {
+		builder.WriteString("  // software: '', // No server software JAR was found\n")
+	}
+	if server.Eula {
+		builder.WriteString("  eula: true,\n")
+	}
+	builder.WriteString("  plugins: [")
+	if len(server.Plugins) > 0 {
+		builder.WriteString("\n")
+		for _, plugin := range server.Plugins {
+			if comment := importComment(plugin, server.Repos); comment != "" {
+				fmt.Fprintf(&builder, "    // %s,%s\n", jsString(plugin.Name), comment)
+			} else if plugin.Pinned {
+				fmt.Fprintf(&builder, "    %s,\n", jsString(plugin.Name+"@"+plugin.Version))
+			} else {
+				fmt.Fprintf(&builder, "    %s,\n", jsString(plugin.Name))
+			}
+		}
+		builder.WriteString("  ")
+	}
+	builder.WriteString("],\n")
+	if server.ServerProperties != nil {
+		builder.WriteString("  server_properties: {")
+		if len(server.ServerProperties) > 0 {
+			builder.WriteString("\n")
+			for _, key := range slices.Sorted(maps.Keys(server.ServerProperties)) {
+				fmt.Fprintf(&builder, "    %s: %s,\n", jsKey(key), jsString(server.ServerProperties[key]))
+			}
+			builder.WriteString("  ")
+		}
+		builder.WriteString("},\n")
+	}
+	builder.WriteString("}\n")
+	return builder.String()
+}
+
+// importComment explains why a JAR isn't provided by the selected repositories, or returns an
+// empty string if it is.
+func importComment(jar ImportedJar, selected []string) string {
+	if slices.ContainsFunc(jar.Repos, func(repo string) bool { return slices.Contains(selected, repo) }) {
+		return ""
+	}
+	comment := " // " + filepath.Base(jar.Path) + " is not in any repository"
+	if len(jar.Others) > 0 {
+		comment += ", other versions are in: " + strings.Join(jar.Others, ", ")
+	}
+	return comment
+}
+
+// jsString quotes a string as a single-quoted JavaScript string literal.
+func jsString(s string) string {
+	var builder strings.Builder
+	builder.WriteByte('\'')
+	for _, r := range s {
+		switch r {
+		case '\'', '\\':
+			builder.WriteByte('\\')
+			builder.WriteRune(r)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\u2028', '\u2029':
+			fmt.Fprintf(&builder, `\u%04x`, r)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&builder, `\x%02x`, r)
+			} else {
+				builder.WriteRune(r)
+			}
+		}
+	}
+	builder.WriteByte('\'')
+	return builder.String()
+}
+
+// jsKey writes an object key as-is if it is a valid identifier, else as a quoted string.
+func jsKey(key string) string {
+	for i, r := range key {
+		isLetter := r == '_' || r == '$' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z')
+		if !isLetter && (i == 0 || r < '0' || r > '9') {
+			return jsString(key)
+		}
+	}
+	if key == "" {
+		return jsString(key)
+	}
+	return key
+}
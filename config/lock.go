@@ -0,0 +1,244 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mythicmc/peridot/repos"
+	"github.com/mythicmc/peridot/utils"
+)
+
+// Lockfile pins the software and plugin JARs resolved for each server, read from the peridot.lock
+// file. When it exists, the locked JARs are deployed instead of the newest ones in the repositories,
+// so that adding JARs to repositories doesn't change what servers get until the lock is updated.
+type Lockfile struct {
+	Servers map[string]LockedServer `json:"servers"`
+}
+
+// LockedServer is the software and plugins resolved for a server when it was locked.
+type LockedServer struct {
+	Software LockedJar            `json:"software"`
+	Plugins  map[string]LockedJar `json:"plugins"`
+}
+
+type LockedJar struct {
+	Type     string `json:"type,omitempty"`    // Only set for software
+	Version  string `json:"version,omitempty"` // Only set for plugins
+	Checksum string `json:"checksum"`
+	Repo     string `json:"repo"`
+}
+
+// LockChange is a locked JAR which was added, updated or removed when locking a server.
+type LockChange struct {
+	Server string
+	Name   string     // Name of the plugin, or type of the software
+	Old    *LockedJar // Nil if the JAR was added
+	New    *LockedJar // Nil if the JAR was removed
+}
+
+type LockfileLoadError struct{ Step string }
+
+func (e LockfileLoadError) Error() string { return "failed to load peridot.lock while " + e.Step }
+
+type OutdatedLockError struct{ Server, Reason string }
+
+func (e OutdatedLockError) Error() string {
+	return "peridot.lock is out of date for server " + e.Server + ": " + e.Reason
+}
+
+func lockfilePath() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(wd, "peridot.lock"), nil
+}
+
+// LoadLockfile loads the peridot.lock file, returning nil if it doesn't exist.
+func LoadLockfile() (*Lockfile, error) {
+	path, err := lockfilePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.Join(LockfileLoadError{Step: "reading file"}, err)
+	}
+	var lockfile Lockfile
+	if err := json.Unmarshal(data, &lockfile); err != nil {
+		return nil, errors.Join(LockfileLoadError{Step: "parsing JSON"}, err)
+	}
+	if lockfile.Servers == nil {
+		lockfile.Servers = make(map[string]LockedServer)
+	}
+	return &lockfile, nil
+}
+
+func (l *Lockfile) Save() error {
+	path, err := lockfilePath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(path, append(data, '\n'), 0644)
+}
+
+// ApplyLockfile sets the locked JARs of every config, failing if a server isn't locked, or if its
+// lock no longer satisfies its config or refers to JARs missing from the repositories.
+func ApplyLockfile(configs Configs, repositories repos.Repositories, lockfile *Lockfile) error {
+	for _, name := range slices.Sorted(maps.Keys(configs)) {
+		config := configs[name]
+		locked, ok := lockfile.Servers[name]
+		if !ok {
+			return OutdatedLockError{Server: name, Reason: "server isn't locked"}
+		} else if reason := locked.outdatedReason(config, repositories); reason != "" {
+			return OutdatedLockError{Server: name, Reason: reason}
+		}
+		config.Lock = &locked
+		configs[name] = config
+	}
+	return nil
+}
+
+// LockServer resolves the software and plugins of a server, keeping the locked JARs which still
+// satisfy its config unless they are being updated, and returns the changes made to the lock. It
+// fails if a resolved plugin requires a newer Minecraft version than the resolved software.
+func (l *Lockfile) LockServer(
+	server string, config Config, repositories repos.Repositories, updateSoftware bool, updatePlugins []string,
+) ([]LockChange, error) {
+	locked, exists := l.Servers[server]
+	changes := make([]LockChange, 0)
+	result := LockedServer{Plugins: make(map[string]LockedJar)}
+
+	var software repos.Software
+	if reason := locked.softwareOutdatedReason(config, repositories); exists && reason == "" && !updateSoftware {
+		result.Software = locked.Software
+		software, _ = repositories.FindSoftware(locked.Software.Type, locked.Software.Checksum,
+			[]string{locked.Software.Repo})
+	} else {
+		var ok bool
+		software, ok = repositories.FindSoftware(config.Software, config.SoftwareChecksum, config.Repos)
+		if !ok {
+			return nil, UnknownPluginSoftwareError{Name: config.Software}
+		}
+		result.Software = LockedJar{Type: software.Type, Checksum: software.Checksum, Repo: software.Repo}
+		if !exists {
+			changes = append(changes, LockChange{Server: server, Name: software.Type, New: &result.Software})
+		} else if result.Software != locked.Software {
+			changes = append(changes, LockChange{
+				Server: server, Name: software.Type, Old: &locked.Software, New: &result.Software,
+			})
+		}
+	}
+
+	for _, requirement := range config.Plugins {
+		name := requirement.Name
+		prev, ok := locked.Plugins[name]
+		if ok && locked.pluginOutdatedReason(requirement, config, repositories) == "" &&
+			!slices.Contains(updatePlugins, name) {
+			plugin, _ := repositories.FindPluginChecksum(name, prev.Checksum, prev.Repo)
+			if err := config.ValidatePluginCompatibility(plugin, software); err != nil {
+				return nil, err
+			}
+			result.Plugins[name] = prev
+			continue
+		}
+		constraint, err := requirement.Constraint()
+		if err != nil {
+			return nil, err
+		}
+		plugin, err := repositories.FindPlugin(name, constraint, config.Repos)
+		if err != nil {
+			return nil, err
+		} else if err := config.ValidatePluginCompatibility(plugin, software); err != nil {
+			return nil, err
+		}
+		jar := LockedJar{Version: plugin.Version, Checksum: plugin.Checksum, Repo: plugin.Repo}
+		result.Plugins[name] = jar
+		if !ok {
+			changes = append(changes, LockChange{Server: server, Name: name, New: &jar})
+		} else if jar != prev {
+			changes = append(changes, LockChange{Server: server, Name: name, Old: &prev, New: &jar})
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(locked.Plugins)) {
+		if _, ok := result.Plugins[name]; !ok {
+			prev := locked.Plugins[name]
+			changes = append(changes, LockChange{Server: server, Name: name, Old: &prev})
+		}
+	}
+
+	l.Servers[server] = result
+	return changes, nil
+}
+
+// outdatedReason returns why the lock of a server no longer satisfies its config, or an empty
+// string if it still does.
+func (l LockedServer) outdatedReason(config Config, repositories repos.Repositories) string {
+	if reason := l.softwareOutdatedReason(config, repositories); reason != "" {
+		return reason
+	}
+	for _, requirement := range config.Plugins {
+		if reason := l.pluginOutdatedReason(requirement, config, repositories); reason != "" {
+			return reason
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(l.Plugins)) {
+		if !config.HasPlugin(name) {
+			return "plugin " + name + " is locked but no longer required"
+		}
+	}
+	return ""
+}
+
+func (l LockedServer) softwareOutdatedReason(config Config, repositories repos.Repositories) string {
+	jar := l.Software
+	switch {
+	case jar.Type != config.Software:
+		return "software is locked to " + utils.PickNonEmptyString(jar.Type, "nothing") +
+			" instead of " + config.Software
+	case !slices.Contains(config.Repos, jar.Repo):
+		return "software is locked to repository " + jar.Repo + ", which is no longer configured"
+	case config.SoftwareChecksum != "" && !strings.EqualFold(config.SoftwareChecksum, jar.Checksum):
+		return "software is locked to checksum " + jar.Checksum + " instead of the pinned " +
+			config.SoftwareChecksum
+	}
+	if _, ok := repositories.FindSoftware(jar.Type, jar.Checksum, []string{jar.Repo}); !ok {
+		return "locked " + jar.Type + " JAR " + utils.ShortHash(jar.Checksum) +
+			" is missing from repository " + jar.Repo
+	}
+	return ""
+}
+
+func (l LockedServer) pluginOutdatedReason(
+	requirement PluginRequirement, config Config, repositories repos.Repositories,
+) string {
+	name := requirement.Name
+	jar, ok := l.Plugins[name]
+	if !ok {
+		return "plugin " + name + " isn't locked"
+	} else if !slices.Contains(config.Repos, jar.Repo) {
+		return "plugin " + name + " is locked to repository " + jar.Repo + ", which is no longer configured"
+	}
+	constraint, err := requirement.Constraint()
+	if err != nil {
+		return err.Error()
+	} else if !constraint.Matches(jar.Version) {
+		return "plugin " + name + " is locked to version " + jar.Version + ", which doesn't match '" +
+			requirement.Version + "'"
+	}
+	if _, ok := repositories.FindPluginChecksum(name, jar.Checksum, jar.Repo); !ok {
+		return "locked JAR of plugin " + name + " " + jar.Version + " is missing from repository " + jar.Repo
+	}
+	return ""
+}
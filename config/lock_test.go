@@ -0,0 +1,242 @@
+package config
+
+import (
+	"errors"
+	"maps"
+	"strings"
+	"testing"
+
+	"github.com/mythicmc/peridot/repos"
+	"github.com/mythicmc/peridot/utils"
+)
+
+// testRepositories returns a repository with two builds of Paper and two versions of each plugin,
+// newest first, along with a plugin requiring Minecraft 1.21.
+func testRepositories() repos.Repositories {
+	paper := []repos.Software{
+		{Repo: "main", Type: "paper", Checksum: "paper-new", Version: "1.20.4"},
+		{Repo: "main", Type: "paper", Checksum: "paper-old", Version: "1.20.4"},
+	}
+	plugin := func(name, version, apiVersion string) repos.Plugin {
+		metadata := utils.PluginMetadata{
+			Platform: "bukkit", ID: name, Name: name, Version: version, APIVersion: apiVersion,
+		}
+		return repos.Plugin{
+			Repo: "main", Name: name, Path: "/repos/main/" + name + "-" + version + ".jar", Version: version,
+			Checksum: strings.ToLower(name) + "-" + version, Metadata: metadata,
+			Platforms: []utils.PluginMetadata{metadata},
+		}
+	}
+	pluginVersions := map[string][]repos.Plugin{
+		"LuckPerms": {plugin("LuckPerms", "5.4.102", ""), plugin("LuckPerms", "5.4.100", "")},
+		"Citizens":  {plugin("Citizens", "2.0.35", ""), plugin("Citizens", "2.0.33", "")},
+		"New":       {plugin("New", "1.0", "1.21")},
+	}
+	repo := repos.Repository{
+		Name:             "main",
+		Software:         map[string]repos.Software{"paper": paper[0]},
+		Plugins:          make(map[string]repos.Plugin),
+		SoftwareVersions: map[string][]repos.Software{"paper": paper},
+		PluginVersions:   pluginVersions,
+	}
+	for name, versions := range pluginVersions {
+		repo.Plugins[name] = versions[0]
+	}
+	return repos.Repositories{"main": repo}
+}
+
+// testLockedServer returns a lock of the old builds of Paper, LuckPerms and Citizens.
+func testLockedServer() LockedServer {
+	return LockedServer{
+		Software: LockedJar{Type: "paper", Checksum: "paper-old", Repo: "main"},
+		Plugins: map[string]LockedJar{
+			"LuckPerms": {Version: "5.4.100", Checksum: "luckperms-5.4.100", Repo: "main"},
+			"Citizens":  {Version: "2.0.33", Checksum: "citizens-2.0.33", Repo: "main"},
+		},
+	}
+}
+
+func testLockConfig() Config {
+	return Config{
+		Repos: []string{"main"}, Software: "paper",
+		Plugins: []PluginRequirement{{Name: "LuckPerms"}, {Name: "Citizens"}},
+	}
+}
+
+func TestApplyLockfile(t *testing.T) {
+	repositories := testRepositories()
+	tests := []struct {
+		name   string
+		edit   func(config *Config, locked *LockedServer, repositories repos.Repositories)
+		reason string // Empty if the lock is applied
+	}{
+		{"up to date", func(*Config, *LockedServer, repos.Repositories) {}, ""},
+		{"unlocked server", nil, "server isn't locked"},
+		{
+			"locked software missing from repos",
+			func(_ *Config, locked *LockedServer, _ repos.Repositories) {
+				locked.Software.Checksum = "paper-deleted"
+			},
+			"locked paper JAR paper-de is missing from repository main",
+		},
+		{
+			"locked plugin missing from repos",
+			func(_ *Config, locked *LockedServer, _ repos.Repositories) {
+				locked.Plugins["Citizens"] = LockedJar{Version: "2.0.30", Checksum: "citizens-2.0.30", Repo: "main"}
+			},
+			"locked JAR of plugin Citizens 2.0.30 is missing from repository main",
+		},
+		{
+			"software changed in config",
+			func(config *Config, _ *LockedServer, _ repos.Repositories) { config.Software = "vanilla" },
+			"software is locked to paper instead of vanilla",
+		},
+		{
+			"software pinned to another checksum",
+			func(config *Config, _ *LockedServer, _ repos.Repositories) { config.SoftwareChecksum = "paper-new" },
+			"software is locked to checksum paper-old instead of the pinned paper-new",
+		},
+		{
+			"repository removed from config",
+			func(config *Config, _ *LockedServer, _ repos.Repositories) { config.Repos = []string{"other"} },
+			"software is locked to repository main, which is no longer configured",
+		},
+		{
+			"plugin added to config",
+			func(config *Config, _ *LockedServer, _ repos.Repositories) {
+				config.Plugins = append(config.Plugins, PluginRequirement{Name: "New"})
+			},
+			"plugin New isn't locked",
+		},
+		{
+			"plugin removed from config",
+			func(config *Config, _ *LockedServer, _ repos.Repositories) { config.Plugins = config.Plugins[:1] },
+			"plugin Citizens is locked but no longer required",
+		},
+		{
+			"plugin constraint changed in config",
+			func(config *Config, _ *LockedServer, _ repos.Repositories) { config.Plugins[1].Version = ">=2.0.35" },
+			"plugin Citizens is locked to version 2.0.33, which doesn't match '>=2.0.35'",
+		},
+	}
+	for _, test := range tests {
+		config, locked := testLockConfig(), testLockedServer()
+		lockfile := &Lockfile{Servers: map[string]LockedServer{}}
+		if test.edit != nil {
+			test.edit(&config, &locked, repositories)
+			lockfile.Servers["hub"] = locked
+		}
+		configs := Configs{"hub": config}
+		err := ApplyLockfile(configs, repositories, lockfile)
+		var outdatedErr OutdatedLockError
+		if test.reason == "" && err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if lock := configs["hub"].Lock; test.reason == "" && (lock == nil || lock.Software.Checksum != "paper-old") {
+			t.Errorf("%s: lock wasn't applied to the config", test.name)
+		} else if test.reason != "" && (!errors.As(err, &outdatedErr) || outdatedErr.Reason != test.reason) {
+			t.Errorf("%s: got %v, want reason %q", test.name, err, test.reason)
+		}
+	}
+}
+
+func TestLockServer(t *testing.T) {
+	repositories := testRepositories()
+	tests := []struct {
+		name           string
+		updateSoftware bool
+		updatePlugins  []string
+		want           map[string]string // Locked checksum of the software and of each plugin
+		changes        int
+	}{
+		{"keeps the lock", false, nil, map[string]string{
+			"paper": "paper-old", "LuckPerms": "luckperms-5.4.100", "Citizens": "citizens-2.0.33",
+		}, 0},
+		{"updates one plugin", false, []string{"Citizens"}, map[string]string{
+			"paper": "paper-old", "LuckPerms": "luckperms-5.4.100", "Citizens": "citizens-2.0.35",
+		}, 1},
+		{"updates everything", true, []string{"LuckPerms", "Citizens"}, map[string]string{
+			"paper": "paper-new", "LuckPerms": "luckperms-5.4.102", "Citizens": "citizens-2.0.35",
+		}, 3},
+	}
+	for _, test := range tests {
+		lockfile := &Lockfile{Servers: map[string]LockedServer{"hub": testLockedServer()}}
+		changes, err := lockfile.LockServer(
+			"hub", testLockConfig(), repositories, test.updateSoftware, test.updatePlugins)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		got := map[string]string{"paper": lockfile.Servers["hub"].Software.Checksum}
+		for name, jar := range lockfile.Servers["hub"].Plugins {
+			got[name] = jar.Checksum
+		}
+		if !maps.Equal(got, test.want) {
+			t.Errorf("%s: locked %v, want %v", test.name, got, test.want)
+		}
+		if len(changes) != test.changes {
+			t.Errorf("%s: %d changes, want %d", test.name, len(changes), test.changes)
+		}
+	}
+}
+
+func TestLockServerStaleLock(t *testing.T) {
+	repositories := testRepositories()
+	config := testLockConfig()
+	config.Plugins = []PluginRequirement{{Name: "Citizens", Version: "2.0.35"}}
+	lockfile := &Lockfile{Servers: map[string]LockedServer{"hub": testLockedServer()}}
+	changes, err := lockfile.LockServer("hub", config, repositories, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The software still satisfies the config, while the plugins no longer do
+	locked := lockfile.Servers["hub"]
+	if locked.Software.Checksum != "paper-old" {
+		t.Errorf("software locked to %s, want paper-old", locked.Software.Checksum)
+	} else if len(locked.Plugins) != 1 || locked.Plugins["Citizens"].Version != "2.0.35" {
+		t.Errorf("plugins locked to %v, want only Citizens 2.0.35", locked.Plugins)
+	}
+	if len(changes) != 2 || changes[0].Name != "Citizens" || changes[0].New == nil ||
+		changes[1].Name != "LuckPerms" || changes[1].New != nil {
+		t.Errorf("changes = %+v, want Citizens updated and LuckPerms removed", changes)
+	}
+}
+
+func TestLockServerMissingJar(t *testing.T) {
+	repositories := testRepositories()
+	locked := testLockedServer()
+	locked.Plugins["LuckPerms"] = LockedJar{Version: "5.4.90", Checksum: "luckperms-5.4.90", Repo: "main"}
+	lockfile := &Lockfile{Servers: map[string]LockedServer{"hub": locked}}
+	if _, err := lockfile.LockServer("hub", testLockConfig(), repositories, false, nil); err != nil {
+		t.Fatal(err)
+	} else if jar := lockfile.Servers["hub"].Plugins["LuckPerms"]; jar.Version != "5.4.102" {
+		t.Errorf("LuckPerms locked to %s, want the missing JAR replaced by 5.4.102", jar.Version)
+	}
+
+	config := testLockConfig()
+	config.Plugins = append(config.Plugins, PluginRequirement{Name: "Missing"})
+	_, err := lockfile.LockServer("hub", config, repositories, false, nil)
+	if !errors.Is(err, repos.ErrPluginNotInRepos) {
+		t.Errorf("locking a missing plugin = %v, want %v", err, repos.ErrPluginNotInRepos)
+	}
+}
+
+func TestLockServerIncompatiblePlugin(t *testing.T) {
+	repositories := testRepositories()
+	config := testLockConfig()
+	config.Plugins = append(config.Plugins, PluginRequirement{Name: "New"})
+	lockfile := &Lockfile{Servers: map[string]LockedServer{"hub": testLockedServer()}}
+	_, err := lockfile.LockServer("hub", config, repositories, false, nil)
+	var incompatibleErr IncompatiblePluginError
+	if !errors.As(err, &incompatibleErr) || incompatibleErr.Plugin != "New" || incompatibleErr.APIVersion != "1.21" {
+		t.Errorf("locking an incompatible plugin = %v, want an IncompatiblePluginError", err)
+	} else if _, ok := lockfile.Servers["hub"].Plugins["New"]; ok {
+		t.Error("incompatible plugin was locked")
+	}
+
+	config.Plugins[2].IgnoreCompatibility = true
+	if _, err := lockfile.LockServer("hub", config, repositories, false, nil); err != nil {
+		t.Fatal(err)
+	} else if jar := lockfile.Servers["hub"].Plugins["New"]; jar.Version != "1.0" {
+		t.Errorf("New locked to %q with ignore_compatibility, want 1.0", jar.Version)
+	}
+}
@@ -1,21 +1,130 @@
 package config
 
 import (
+	"encoding/json"
 	"errors"
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 
 	"github.com/mythicmc/peridot/repos"
+	"github.com/mythicmc/peridot/utils"
 )
 
 type Config struct {
 	Location         string                 `json:"location"`
 	Repos            []string               `json:"repos"`
 	Software         string                 `json:"software"`          // Supported: "vanilla", "paper", "velocity"
-	ServerProperties map[string]interface{} `json:"server_properties"` // Supported: string, float64, bool
-	Plugins          []string               `json:"plugins"`
+	ServerProperties map[string]interface{} `json:"server_properties"` // Supported: string, float64, bool, null to delete
+	Plugins          []PluginRequirement    `json:"plugins"`
+	// Adds the missing hard dependencies of plugins to the plugins list, instead of failing validation
+	IncludeDependencies bool `json:"include_dependencies"`
+	// Checksum the software is pinned to, from software: {type, checksum}
+	SoftwareChecksum string             `json:"-"`
+	Tags             []string           `json:"tags"`
+	Octyne           utils.OctyneConfig `json:"octyne"` // Defaults to the Octyne settings
+	// Accepts the Minecraft EULA in eula.txt, only supported on vanilla and Paper servers
+	Eula bool `json:"eula"`
+	// Patches deep merged into the Bukkit, Spigot and Paper configs, only supported on Paper servers
+	BukkitYml          map[string]interface{} `json:"bukkit_yml"`
+	SpigotYml          map[string]interface{} `json:"spigot_yml"`
+	PaperGlobal        map[string]interface{} `json:"paper_global"`         // config/paper-global.yml
+	PaperWorldDefaults map[string]interface{} `json:"paper_world_defaults"` // config/paper-world-defaults.yml
+	// Patch deep merged into velocity.toml, only supported on Velocity servers
+	VelocityToml map[string]interface{} `json:"velocity_toml"`
+	// Servers registered in velocity.toml, only supported on Velocity servers
+	VelocityServers *VelocityServers `json:"velocity_servers"`
+	// Generated [servers] and [forced-hosts] tables of velocity.toml, set when loading configs
+	VelocityServerTables map[string]interface{} `json:"-"`
+	// Proxy whose modern forwarding secret this server uses, set when loading configs
+	ForwardingProxy string `json:"-"`
+	// Modern forwarding secret shared by a proxy and its backends, set before preparing updates
+	ForwardingSecret string `json:"-"`
+	// JARs locked in peridot.lock, deployed instead of the newest ones. Set when loading configs, for
+	// dependencies to be resolved against them, and checked by ApplyLockfile before preparing updates
+	Lock *LockedServer `json:"-"`
+	// Address and hostnames of this server when registered on a Velocity proxy
+	Address     string   `json:"address"` // Defaults to 127.0.0.1
+	ForcedHosts []string `json:"forced_hosts"`
+	// Config files managed in each plugin's data folder, keyed by folder name, then by file path
+	PluginConfigs map[string]map[string]ConfigFile `json:"plugin_configs"`
+}
+
+// ConfigFile is a config file managed by Peridot, either written with the given contents, or
+// patched by deep merging an object into the existing file.
+type ConfigFile struct {
+	Content *string                `json:"content"`
+	Merge   map[string]interface{} `json:"merge"`  // Null values delete the key from the file
+	Format  string                 `json:"format"` // Defaults to the format of the file extension
+}
+
+// PluginRequirement is a plugin required by a config, optionally pinned to the versions matching a
+// constraint. Plugins are written either as 'Name', 'Name@constraint' or {name, version}.
+type PluginRequirement struct {
+	Name    string `json:"name"`
+	Version string `json:"version"` // Version constraint, e.g. "5.4.102" or ">=2.0.33 <2.1"
+	// Deploys the plugin even if its api-version is newer than the Minecraft version of the software
+	IgnoreCompatibility bool `json:"ignore_compatibility"`
+}
+
+func (p *PluginRequirement) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		p.Name, p.Version, _ = strings.Cut(name, "@")
+		return nil
+	}
+	type pluginRequirement PluginRequirement // Avoid recursing into UnmarshalJSON
+	return json.Unmarshal(data, (*pluginRequirement)(p))
+}
+
+// Constraint parses the version constraint of the plugin.
+func (p PluginRequirement) Constraint() (utils.VersionConstraint, error) {
+	return utils.ParseVersionConstraint(p.Version)
+}
+
+// UnmarshalJSON decodes a config, with the software written either as its type, or as an object
+// with its type and the checksum it is pinned to.
+func (c *Config) UnmarshalJSON(data []byte) error {
+	type config Config // Avoid recursing into UnmarshalJSON
+	var raw struct {
+		config
+		Software json.RawMessage `json:"software"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*c = Config(raw.config)
+	if len(raw.Software) == 0 || string(raw.Software) == "null" {
+		return nil
+	} else if err := json.Unmarshal(raw.Software, &c.Software); err == nil {
+		return nil
+	}
+	var software struct {
+		Type     string `json:"type"`
+		Checksum string `json:"checksum"`
+	}
+	if err := json.Unmarshal(raw.Software, &software); err != nil {
+		return err
+	}
+	c.Software, c.SoftwareChecksum = software.Type, software.Checksum
+	return nil
+}
+
+// HasPlugin checks if a plugin is required by the config.
+func (c Config) HasPlugin(name string) bool {
+	_, ok := c.Plugin(name)
+	return ok
+}
+
+// Plugin returns the requirement of the config for a plugin.
+func (c Config) Plugin(name string) (PluginRequirement, bool) {
+	index := slices.IndexFunc(c.Plugins, func(plugin PluginRequirement) bool { return plugin.Name == name })
+	if index == -1 {
+		return PluginRequirement{}, false
+	}
+	return c.Plugins[index], true
 }
 
 type Configs map[string]Config
@@ -29,12 +138,18 @@ func (e ConfigLoadError) Error() string {
 	return "failed to load config " + e.Name + " while " + e.Step
 }
 
-func LoadConfigs(repositories repos.Repositories) (Configs, error) {
+// LoadConfigs loads and validates every config in the configs/ folder, skipping the excluded
+// servers as if their configs didn't exist.
+func LoadConfigs(repositories repos.Repositories, excluded ...string) (Configs, error) {
 	wd, err := os.Getwd()
 	if err != nil {
 		return nil, err
 	}
 	configs := make(Configs)
+	lockfile, err := LoadLockfile()
+	if err != nil {
+		return nil, err
+	}
 	configFolder := filepath.Join(wd, "configs")
 	configFiles, err := os.ReadDir(configFolder)
 	if err != nil && os.IsNotExist(err) {
@@ -49,6 +164,9 @@ func LoadConfigs(repositories repos.Repositories) (Configs, error) {
 		}
 		filePath := filepath.Join(configFolder, configFile.Name())
 		configName := configFile.Name()[:strings.LastIndex(configFile.Name(), ".")]
+		if slices.Contains(excluded, configName) {
+			continue
+		}
 		configJs, err := os.ReadFile(filePath)
 		if err != nil {
 			return nil, errors.Join(ConfigLoadError{Name: configName, Step: "reading file"}, err)
@@ -57,11 +175,22 @@ func LoadConfigs(repositories repos.Repositories) (Configs, error) {
 		if err != nil {
 			return nil, errors.Join(ConfigLoadError{Name: configName, Step: "executing JS"}, err)
 		}
+		if lockfile != nil {
+			if locked, ok := lockfile.Servers[configName]; ok {
+				config.Lock = &locked
+			}
+		}
+		if config.IncludeDependencies {
+			config = includeDependencies(config, repositories)
+		}
 		err = ValidateConfig(config, repositories)
 		if err != nil {
 			return nil, errors.Join(ConfigLoadError{Name: configName, Step: "validating config"}, err)
 		}
 		configs[configName] = config
 	}
+	if err := resolveVelocityServers(configs); err != nil {
+		return nil, err
+	}
 	return configs, nil
 }
@@ -0,0 +1,144 @@
+package config
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+func TestPluginRequirementUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input string
+		want  PluginRequirement
+	}{
+		{`"LuckPerms"`, PluginRequirement{Name: "LuckPerms"}},
+		{`"LuckPerms@5.4.102"`, PluginRequirement{Name: "LuckPerms", Version: "5.4.102"}},
+		{`"Citizens@>=2.0.33 <2.1"`, PluginRequirement{Name: "Citizens", Version: ">=2.0.33 <2.1"}},
+		{`"Citizens@"`, PluginRequirement{Name: "Citizens"}},
+		{`{"name": "LuckPerms"}`, PluginRequirement{Name: "LuckPerms"}},
+		{`{"name": "LuckPerms", "version": "5.4.102"}`, PluginRequirement{Name: "LuckPerms", Version: "5.4.102"}},
+		{
+			`{"name": "Citizens", "version": ">=2.0", "ignore_compatibility": true}`,
+			PluginRequirement{Name: "Citizens", Version: ">=2.0", IgnoreCompatibility: true},
+		},
+	}
+	for _, test := range tests {
+		var got PluginRequirement
+		if err := json.Unmarshal([]byte(test.input), &got); err != nil {
+			t.Errorf("unmarshalling %s: %v", test.input, err)
+		} else if got != test.want {
+			t.Errorf("unmarshalling %s = %+v, want %+v", test.input, got, test.want)
+		}
+	}
+}
+
+func TestPluginRequirementUnmarshalJSONErrors(t *testing.T) {
+	for _, input := range []string{`5`, `["LuckPerms"]`, `{"name": 5}`, `{"name": "LuckPerms", "version": 5}`} {
+		var got PluginRequirement
+		if err := json.Unmarshal([]byte(input), &got); err == nil {
+			t.Errorf("unmarshalling %s = %+v, want an error", input, got)
+		}
+	}
+}
+
+func TestPluginRequirementConstraint(t *testing.T) {
+	tests := []struct {
+		requirement string
+		version     string
+		matches     bool
+	}{
+		{`"LuckPerms"`, "5.4.102", true},
+		{`"LuckPerms@5.4.102"`, "5.4.102", true},
+		{`"LuckPerms@5.4.102"`, "5.4.103", false},
+		{`{"name": "Citizens", "version": ">=2.0.33, <2.1"}`, "2.0.35", true},
+		{`{"name": "Citizens", "version": ">=2.0.33, <2.1"}`, "2.1.0", false},
+	}
+	for _, test := range tests {
+		var requirement PluginRequirement
+		if err := json.Unmarshal([]byte(test.requirement), &requirement); err != nil {
+			t.Fatal(err)
+		}
+		constraint, err := requirement.Constraint()
+		if err != nil {
+			t.Errorf("%s: %v", test.requirement, err)
+		} else if constraint.Matches(test.version) != test.matches {
+			t.Errorf("%s matches %s = %t, want %t", test.requirement, test.version, !test.matches, test.matches)
+		}
+	}
+	for _, input := range []string{`"LuckPerms@~5.4"`, `"LuckPerms@^5"`, `"LuckPerms@5.*"`, `"LuckPerms@>="`} {
+		var requirement PluginRequirement
+		if err := json.Unmarshal([]byte(input), &requirement); err != nil {
+			t.Fatal(err)
+		} else if _, err := requirement.Constraint(); err == nil {
+			t.Errorf("constraint of %s succeeded, want an error", input)
+		}
+	}
+}
+
+func TestConfigUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		input    string
+		software string
+		checksum string
+		plugins  []PluginRequirement
+	}{
+		{`{}`, "", "", nil},
+		{`{"software": null}`, "", "", nil},
+		{`{"software": "paper"}`, "paper", "", nil},
+		{`{"software": {"type": "paper"}}`, "paper", "", nil},
+		{`{"software": {"type": "paper", "checksum": "ABC123"}}`, "paper", "ABC123", nil},
+		{
+			`{"software": "velocity", "plugins": ["LuckPerms@5.4.102", {"name": "Citizens"}]}`,
+			"velocity", "",
+			[]PluginRequirement{{Name: "LuckPerms", Version: "5.4.102"}, {Name: "Citizens"}},
+		},
+	}
+	for _, test := range tests {
+		var got Config
+		if err := json.Unmarshal([]byte(test.input), &got); err != nil {
+			t.Errorf("unmarshalling %s: %v", test.input, err)
+			continue
+		}
+		if got.Software != test.software || got.SoftwareChecksum != test.checksum {
+			t.Errorf("unmarshalling %s = software %q with checksum %q, want %q with %q",
+				test.input, got.Software, got.SoftwareChecksum, test.software, test.checksum)
+		}
+		if !slices.Equal(got.Plugins, test.plugins) {
+			t.Errorf("unmarshalling %s = plugins %+v, want %+v", test.input, got.Plugins, test.plugins)
+		}
+	}
+}
+
+func TestConfigUnmarshalJSONKeepsOtherFields(t *testing.T) {
+	input := `{
+		"location": "/srv/hub", "repos": ["main"], "tags": ["hub"],
+		"software": {"type": "paper", "checksum": "abc"},
+		"server_properties": {"server-port": 25566}, "include_dependencies": true
+	}`
+	var got Config
+	if err := json.Unmarshal([]byte(input), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Location != "/srv/hub" || !slices.Equal(got.Repos, []string{"main"}) ||
+		!slices.Equal(got.Tags, []string{"hub"}) || !got.IncludeDependencies ||
+		got.ServerProperties["server-port"] != 25566.0 {
+		t.Errorf("unmarshalling %s = %+v", input, got)
+	}
+}
+
+func TestConfigUnmarshalJSONErrors(t *testing.T) {
+	for _, input := range []string{
+		`{"software": 5}`,
+		`{"software": ["paper"]}`,
+		`{"software": {"type": 5}}`,
+		`{"software": {"type": "paper", "checksum": 5}}`,
+		`{"plugins": [5]}`,
+		`{"plugins": "LuckPerms"}`,
+		`{"location": 5, "software": "paper"}`,
+	} {
+		var got Config
+		if err := json.Unmarshal([]byte(input), &got); err == nil {
+			t.Errorf("unmarshalling %s = %+v, want an error", input, got)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"path"
+	"slices"
+)
+
+type UnknownServerError struct{ Name string }
+
+func (e UnknownServerError) Error() string { return "no configured server matches: " + e.Name }
+
+// Select returns the configs matching any of the given server names or glob patterns, and
+// having at least one of the given tags. Empty patterns or tags match every server.
+func (c Configs) Select(patterns []string, tags []string) (Configs, error) {
+	selected := make(Configs)
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, err
+		}
+		matched := false
+		for name := range c {
+			if ok, _ := path.Match(pattern, name); ok {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return nil, UnknownServerError{Name: pattern}
+		}
+	}
+	for name, config := range c {
+		if len(patterns) > 0 && !slices.ContainsFunc(patterns, func(pattern string) bool {
+			ok, _ := path.Match(pattern, name)
+			return ok
+		}) {
+			continue
+		}
+		if len(tags) > 0 && !slices.ContainsFunc(tags, func(tag string) bool {
+			return slices.Contains(config.Tags, tag)
+		}) {
+			continue
+		}
+		selected[name] = config
+	}
+	return selected, nil
+}
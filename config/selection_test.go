@@ -0,0 +1,60 @@
+package config
+
+import (
+	"maps"
+	"path"
+	"slices"
+	"testing"
+)
+
+func TestConfigsSelect(t *testing.T) {
+	configs := Configs{
+		"hub":     {Tags: []string{"hub"}},
+		"lobby-1": {Tags: []string{"lobby", "eu"}},
+		"lobby-2": {Tags: []string{"lobby", "us"}},
+		"proxy":   {},
+	}
+	tests := []struct {
+		name     string
+		patterns []string
+		tags     []string
+		want     []string
+	}{
+		{"everything", nil, nil, []string{"hub", "lobby-1", "lobby-2", "proxy"}},
+		{"names", []string{"hub", "proxy"}, nil, []string{"hub", "proxy"}},
+		{"glob", []string{"lobby-*"}, nil, []string{"lobby-1", "lobby-2"}},
+		{"overlapping globs", []string{"lobby-*", "*-1"}, nil, []string{"lobby-1", "lobby-2"}},
+		{"character class", []string{"lobby-[2-9]"}, nil, []string{"lobby-2"}},
+		{"tag", nil, []string{"lobby"}, []string{"lobby-1", "lobby-2"}},
+		{"any of tags", nil, []string{"hub", "us"}, []string{"hub", "lobby-2"}},
+		{"names and tags", []string{"lobby-*"}, []string{"eu"}, []string{"lobby-1"}},
+		{"no server with tag", nil, []string{"missing"}, []string{}},
+		{"matching name without tag", []string{"proxy"}, []string{"lobby"}, []string{}},
+	}
+	for _, test := range tests {
+		selected, err := configs.Select(test.patterns, test.tags)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if got := slices.Sorted(maps.Keys(selected)); !slices.Equal(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestConfigsSelectErrors(t *testing.T) {
+	configs := Configs{"hub": {}, "lobby-1": {}}
+	tests := []struct {
+		name     string
+		patterns []string
+		err      error
+	}{
+		{"unknown name", []string{"hub", "survival"}, UnknownServerError{Name: "survival"}},
+		{"glob matching nothing", []string{"lobby-*", "survival-*"}, UnknownServerError{Name: "survival-*"}},
+		{"malformed glob", []string{"lobby-["}, path.ErrBadPattern},
+	}
+	for _, test := range tests {
+		if selected, err := configs.Select(test.patterns, nil); err != test.err {
+			t.Errorf("%s: got %v, %v, want error %v", test.name, selected, err, test.err)
+		}
+	}
+}
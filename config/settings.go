@@ -0,0 +1,42 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/mythicmc/peridot/utils"
+)
+
+// Settings are global Peridot settings, read from the peridot.json file.
+type Settings struct {
+	Octyne utils.OctyneConfig `json:"octyne"` // Default Octyne connection for all servers
+}
+
+type SettingsLoadError struct{ Step string }
+
+func (e SettingsLoadError) Error() string { return "failed to load settings while " + e.Step }
+
+func LoadSettings() (Settings, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return Settings{}, err
+	}
+	var settings Settings
+	data, err := os.ReadFile(filepath.Join(wd, "peridot.json"))
+	if err != nil && os.IsNotExist(err) {
+		return settings, nil
+	} else if err != nil {
+		return Settings{}, errors.Join(SettingsLoadError{Step: "reading file"}, err)
+	}
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return Settings{}, errors.Join(SettingsLoadError{Step: "parsing JSON"}, err)
+	}
+	if settings.Octyne.URL != "" {
+		if _, err := utils.NewOctyneClient(settings.Octyne); err != nil {
+			return Settings{}, errors.Join(SettingsLoadError{Step: "validating Octyne settings"}, err)
+		}
+	}
+	return settings, nil
+}
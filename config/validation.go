@@ -2,10 +2,15 @@ package config
 
 import (
 	"errors"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
 
 	"github.com/mythicmc/peridot/repos"
+	"github.com/mythicmc/peridot/utils"
 )
 
 func ValidateConfig(config Config, repositories repos.Repositories) error {
@@ -25,24 +30,49 @@ func ValidateConfig(config Config, repositories repos.Repositories) error {
 		return err
 	}
 
+	if err := validateConfigDependencies(config, repositories); err != nil {
+		return err
+	}
+
+	if err := validateConfigOctyne(config); err != nil {
+		return err
+	}
+
+	if err := validateConfigServerConfigs(config); err != nil {
+		return err
+	}
+
+	if err := validateConfigPluginConfigs(config); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 var ErrInvalidLocationPath = errors.New("invalid location: must be an absolute path")
 
-var ErrLocationNotExists = errors.New("invalid location: parent of location is not a valid folder")
+var ErrLocationNotFolder = errors.New("invalid location: location or one of its parents is not a folder")
 
+// validateConfigLocation checks that the location is a folder, or can be created as one if it doesn't
+// exist yet, in which case the server is created from scratch on apply.
 func validateConfigLocation(config Config) error {
 	if config.Location == "" || !filepath.IsAbs(config.Location) {
 		return ErrInvalidLocationPath
-	} else if stat, err := os.Stat(filepath.Dir(config.Location)); os.IsNotExist(err) {
-		return ErrLocationNotExists
-	} else if err != nil {
-		return err
-	} else if !stat.IsDir() {
-		return ErrLocationNotExists
 	}
-	return nil
+	for path := config.Location; ; path = filepath.Dir(path) {
+		if stat, err := os.Stat(path); err == nil {
+			if !stat.IsDir() {
+				return ErrLocationNotFolder
+			}
+			return nil
+		} else if errors.Is(err, syscall.ENOTDIR) {
+			return ErrLocationNotFolder
+		} else if !os.IsNotExist(err) {
+			return err
+		} else if path == filepath.Dir(path) {
+			return ErrLocationNotFolder
+		}
+	}
 }
 
 var ErrNoReposConfigured = errors.New("invalid repositories: at least one repository must be specified")
@@ -74,6 +104,12 @@ func (e UnknownPluginSoftwareError) Error() string {
 	return "unknown plugin/software specified: " + e.Name + " not found in configured repositories"
 }
 
+type UnsatisfiedSoftwareChecksumError struct{ Software, Checksum string }
+
+func (e UnsatisfiedSoftwareChecksumError) Error() string {
+	return "no " + e.Software + " software with checksum " + e.Checksum + " found in configured repositories"
+}
+
 func validateConfigSoftware(config Config, repositories repos.Repositories) error {
 	if config.Software != "vanilla" && config.Software != "paper" && config.Software != "velocity" {
 		return ErrInvalidSoftware
@@ -88,26 +124,190 @@ func validateConfigSoftware(config Config, repositories repos.Repositories) erro
 	if !softwareFound {
 		return UnknownPluginSoftwareError{Name: config.Software}
 	}
+	if config.SoftwareChecksum != "" {
+		if _, ok := repositories.FindSoftware(config.Software, config.SoftwareChecksum, config.Repos); !ok {
+			return UnsatisfiedSoftwareChecksumError{Software: config.Software, Checksum: config.SoftwareChecksum}
+		}
+	}
 	return nil
 }
 
 var ErrInvalidPlugin = errors.New("invalid plugin: empty string found in plugins list")
 
+type DuplicatePluginError struct{ Name string }
+
+func (e DuplicatePluginError) Error() string {
+	return "invalid plugin: " + e.Name + " is listed more than once"
+}
+
+type UnsatisfiedPluginVersionError struct {
+	Name       string
+	Constraint string
+	Versions   []string // Versions available in the configured repositories
+}
+
+func (e UnsatisfiedPluginVersionError) Error() string {
+	available := "none"
+	if len(e.Versions) > 0 {
+		available = strings.Join(e.Versions, ", ")
+	}
+	return "no version of plugin " + e.Name + " matching '" + e.Constraint + "' found in configured " +
+		"repositories (available: " + available + ")"
+}
+
+type UnsupportedPluginPlatformError struct {
+	Name      string
+	Software  string
+	Platforms []string // Platforms supported by the plugin
+}
+
+func (e UnsupportedPluginPlatformError) Error() string {
+	return "plugin " + e.Name + " doesn't support " + e.Software + " servers (supported platforms: " +
+		strings.Join(e.Platforms, ", ") + ")"
+}
+
 func validateConfigPlugins(config Config, repositories repos.Repositories) error {
-	for _, plugin := range config.Plugins {
-		if plugin == "" {
+	for i, plugin := range config.Plugins {
+		if plugin.Name == "" {
 			return ErrInvalidPlugin
+		} else if slices.ContainsFunc(config.Plugins[:i], func(other PluginRequirement) bool {
+			return other.Name == plugin.Name
+		}) {
+			return DuplicatePluginError{Name: plugin.Name}
 		}
 		pluginFound := false
 		for _, repo := range repositories {
-			if _, ok := repo.Plugins[plugin]; ok {
+			if _, ok := repo.Plugins[plugin.Name]; ok {
 				pluginFound = true
 				break
 			}
 		}
 		if !pluginFound {
-			return UnknownPluginSoftwareError{Name: plugin}
+			return UnknownPluginSoftwareError{Name: plugin.Name}
+		}
+		constraint, err := plugin.Constraint()
+		if err != nil {
+			return err
+		}
+		found, err := repositories.FindPlugin(plugin.Name, constraint, config.Repos)
+		if err != nil {
+			versions := make([]string, 0)
+			for _, repo := range config.Repos {
+				for _, version := range repositories[repo].PluginVersions[plugin.Name] {
+					if !slices.Contains(versions, version.Version) {
+						versions = append(versions, version.Version)
+					}
+				}
+			}
+			return UnsatisfiedPluginVersionError{Name: plugin.Name, Constraint: plugin.Version, Versions: versions}
+		} else if !found.Supports(config.Software) {
+			return UnsupportedPluginPlatformError{
+				Name: plugin.Name, Software: config.Software, Platforms: found.SupportedPlatforms(),
+			}
 		}
 	}
 	return nil
 }
+
+type IncompatiblePluginError struct {
+	Plugin, Version, APIVersion string
+	Software, SoftwareVersion   string
+}
+
+func (e IncompatiblePluginError) Error() string {
+	return "plugin " + e.Plugin + " " + e.Version + " requires Minecraft " + e.APIVersion + " (api-version), " +
+		"but the " + e.Software + " software is for Minecraft " + e.SoftwareVersion + " (set ignore_compatibility " +
+		"on the plugin to deploy it anyway)"
+}
+
+// ValidatePluginCompatibility checks that a plugin doesn't require a newer Minecraft version than
+// the software, unless the config ignores the plugin's compatibility. Unlike the rest of validation,
+// this is checked per server when preparing its updates, as it depends on the JARs locked for it.
+func (c Config) ValidatePluginCompatibility(plugin repos.Plugin, software repos.Software) error {
+	if requirement, _ := c.Plugin(plugin.Name); requirement.IgnoreCompatibility || plugin.CompatibleWith(software) {
+		return nil
+	}
+	return IncompatiblePluginError{
+		Plugin:          plugin.Name,
+		Version:         plugin.Version,
+		APIVersion:      plugin.MetadataFor(software.Type).APIVersion,
+		Software:        software.Type,
+		SoftwareVersion: software.Version,
+	}
+}
+
+func validateConfigOctyne(config Config) error {
+	if config.Octyne.URL == "" {
+		return nil
+	}
+	_, err := utils.NewOctyneClient(config.Octyne)
+	return err
+}
+
+type UnsupportedConfigKeyError struct{ Key, Software string }
+
+func (e UnsupportedConfigKeyError) Error() string {
+	return "invalid " + e.Key + ": not supported by software " + e.Software
+}
+
+func validateConfigServerConfigs(config Config) error {
+	keys := make(map[string]bool)
+	if config.Software != "paper" {
+		keys["bukkit_yml"] = config.BukkitYml != nil
+		keys["spigot_yml"] = config.SpigotYml != nil
+		keys["paper_global"] = config.PaperGlobal != nil
+		keys["paper_world_defaults"] = config.PaperWorldDefaults != nil
+	}
+	if config.Software == "velocity" {
+		keys["eula"] = config.Eula
+	} else {
+		keys["velocity_toml"] = config.VelocityToml != nil
+		keys["velocity_servers"] = config.VelocityServers != nil
+	}
+	for _, key := range slices.Sorted(maps.Keys(keys)) {
+		if keys[key] {
+			return UnsupportedConfigKeyError{Key: key, Software: config.Software}
+		}
+	}
+	return nil
+}
+
+type InvalidManagedFileError struct{ Path, Reason string }
+
+func (e InvalidManagedFileError) Error() string {
+	return "invalid managed config file " + e.Path + ": " + e.Reason
+}
+
+func validateConfigPluginConfigs(config Config) error {
+	for folder, files := range config.PluginConfigs {
+		if folder == "" || folder == "." || folder == ".." || strings.ContainsAny(folder, `/\`) {
+			return InvalidManagedFileError{Path: folder, Reason: "plugin folder must be a plain folder name"}
+		}
+		for name, file := range files {
+			path := filepath.Join("plugins", folder, name)
+			if !filepath.IsLocal(name) {
+				return InvalidManagedFileError{Path: path, Reason: "path must be relative to the plugin folder"}
+			}
+			if err := validateConfigFile(path, file); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func validateConfigFile(path string, file ConfigFile) error {
+	if (file.Content == nil) == (file.Merge == nil) {
+		return InvalidManagedFileError{Path: path, Reason: "exactly one of 'content' or 'merge' must be specified"}
+	} else if file.Merge == nil {
+		return nil
+	} else if file.Format == "" {
+		_, err := utils.ConfigFormatFromPath(path)
+		return err
+	}
+	switch file.Format {
+	case utils.ConfigFormatYAML, utils.ConfigFormatJSON, utils.ConfigFormatTOML, utils.ConfigFormatProperties:
+		return nil
+	}
+	return utils.UnknownConfigFormatError{Name: path}
+}
@@ -0,0 +1,186 @@
+package config
+
+import (
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+)
+
+const defaultServerPort = 25565
+
+// VelocityServers selects the servers registered on a Velocity proxy, generating the [servers], try
+// and [forced-hosts] sections of velocity.toml from their configs.
+type VelocityServers struct {
+	Servers []string `json:"servers"` // Names or glob patterns, defaults to every non-Velocity server
+	Tags    []string `json:"tags"`
+	Try     []string `json:"try"` // Defaults to every selected server
+	// Enables modern forwarding with a secret shared between the proxy and every selected server
+	Forwarding bool `json:"forwarding"`
+}
+
+type VelocityServersError struct {
+	Name string
+	Err  error
+}
+
+func (e VelocityServersError) Error() string {
+	return "invalid velocity_servers of config " + e.Name + ": " + e.Err.Error()
+}
+
+func (e VelocityServersError) Unwrap() error { return e.Err }
+
+type ForwardingConflictError struct{ Name, Reason string }
+
+func (e ForwardingConflictError) Error() string {
+	return "cannot enable modern forwarding for server " + e.Name + ": " + e.Reason
+}
+
+type UnknownTryServerError struct{ Name string }
+
+func (e UnknownTryServerError) Error() string {
+	return "server " + e.Name + " in try is not one of the selected servers"
+}
+
+type ServerReferencedError struct{ Name, Proxy string }
+
+func (e ServerReferencedError) Error() string {
+	return "server " + e.Name + " is listed by name in the velocity_servers of config " + e.Proxy +
+		", remove it from configs/" + e.Proxy + ".js first"
+}
+
+type InvalidServerPortError struct{ Name string }
+
+func (e InvalidServerPortError) Error() string {
+	return "invalid server-port in server properties of config " + e.Name
+}
+
+// resolveVelocityServers generates the server tables of every Velocity config with velocity_servers.
+func resolveVelocityServers(configs Configs) error {
+	for _, name := range slices.Sorted(maps.Keys(configs)) {
+		config := configs[name]
+		if config.VelocityServers == nil {
+			continue
+		}
+		tables, err := velocityServerTables(configs, *config.VelocityServers)
+		if err != nil {
+			return VelocityServersError{Name: name, Err: err}
+		}
+		config.VelocityServerTables = tables
+		configs[name] = config
+		if config.VelocityServers.Forwarding {
+			if err := enableForwarding(configs, name); err != nil {
+				return VelocityServersError{Name: name, Err: err}
+			}
+		}
+	}
+	return nil
+}
+
+// enableForwarding sets up the backends of a proxy for modern forwarding. Backends must run Paper,
+// and have online mode disabled, as players are authenticated by the proxy instead.
+func enableForwarding(configs Configs, proxy string) error {
+	servers := configs[proxy].VelocityServerTables["servers"].(map[string]interface{})
+	for _, name := range slices.Sorted(maps.Keys(servers)) {
+		backend, ok := configs[name]
+		if !ok {
+			continue // The try list
+		} else if backend.Software != "paper" {
+			return ForwardingConflictError{Name: name, Reason: "only Paper servers support modern forwarding"}
+		} else if backend.ForwardingProxy != "" {
+			return ForwardingConflictError{Name: name, Reason: "it is already forwarded by " + backend.ForwardingProxy}
+		} else if onlineMode, ok := backend.ServerProperties["online-mode"]; ok &&
+			onlineMode != false && onlineMode != "false" {
+			return ForwardingConflictError{Name: name, Reason: "online-mode must be false in its server properties"}
+		}
+		backend.ForwardingProxy = proxy
+		backend.ServerProperties = maps.Clone(backend.ServerProperties)
+		if backend.ServerProperties == nil {
+			backend.ServerProperties = make(map[string]interface{})
+		}
+		backend.ServerProperties["online-mode"] = false
+		configs[name] = backend
+	}
+	return nil
+}
+
+func velocityServerTables(configs Configs, velocityServers VelocityServers) (map[string]interface{}, error) {
+	selected, err := configs.Select(velocityServers.Servers, velocityServers.Tags)
+	if err != nil {
+		return nil, err
+	}
+	servers := make(map[string]interface{})
+	forcedHosts := make(map[string]interface{})
+	names := make([]interface{}, 0, len(selected))
+	for _, name := range slices.Sorted(maps.Keys(selected)) {
+		backend := selected[name]
+		if backend.Software == "velocity" {
+			continue
+		}
+		port, ok := serverPort(backend)
+		if !ok {
+			return nil, InvalidServerPortError{Name: name}
+		}
+		host := backend.Address
+		if host == "" {
+			host = "127.0.0.1"
+		}
+		servers[name] = net.JoinHostPort(host, strconv.Itoa(port))
+		names = append(names, name)
+		for _, hostname := range backend.ForcedHosts {
+			hostServers, _ := forcedHosts[hostname].([]interface{})
+			forcedHosts[hostname] = append(hostServers, name)
+		}
+	}
+
+	try := names
+	if len(velocityServers.Try) > 0 {
+		try = make([]interface{}, 0, len(velocityServers.Try))
+		for _, name := range velocityServers.Try {
+			if _, ok := servers[name]; !ok {
+				return nil, UnknownTryServerError{Name: name}
+			}
+			try = append(try, name)
+		}
+	}
+	servers["try"] = try
+	return map[string]interface{}{"servers": servers, "forced-hosts": forcedHosts}, nil
+}
+
+// CheckUnreferenced checks that no Velocity config lists a server by name in the servers or try of
+// its velocity_servers, including patterns matching no other server, so that the server's config can
+// be removed without the selection of these configs failing.
+func (c Configs) CheckUnreferenced(server string) error {
+	for _, proxy := range slices.Sorted(maps.Keys(c)) {
+		velocityServers := c[proxy].VelocityServers
+		if velocityServers == nil {
+			continue
+		} else if slices.Contains(velocityServers.Try, server) {
+			return ServerReferencedError{Name: server, Proxy: proxy}
+		}
+		for _, pattern := range velocityServers.Servers {
+			matched, err := c.Select([]string{pattern}, nil)
+			if _, ok := matched[server]; err == nil && ok && len(matched) == 1 {
+				return ServerReferencedError{Name: server, Proxy: proxy}
+			}
+		}
+	}
+	return nil
+}
+
+// serverPort returns the server-port of a server from its server properties, defaulting to 25565.
+func serverPort(config Config) (int, bool) {
+	switch port := config.ServerProperties["server-port"].(type) {
+	case nil:
+		return defaultServerPort, true
+	case float64:
+		if port == float64(int(port)) && port > 0 && port < 65536 {
+			return int(port), true
+		}
+	case string:
+		if port, err := strconv.Atoi(port); err == nil && port > 0 && port < 65536 {
+			return port, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,260 @@
+package deploy
+
+import (
+	"bytes"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mythicmc/peridot/config"
+	"github.com/mythicmc/peridot/utils"
+)
+
+type ConfigFileUpdateOperation struct {
+	Name         string               `json:"name"` // Path relative to the server location
+	Path         string               `json:"path"`
+	Changes      []utils.ConfigChange `json:"changes,omitempty"` // Empty if the whole file is replaced
+	PrevChecksum string               `json:"prev_checksum"`     // Empty if missing
+	NewChecksum  string               `json:"new_checksum"`
+	Content      string               `json:"content"` // Empty in plans and history if sensitive
+	// Whether the content contains secrets, in which case it isn't saved in plans or history
+	Sensitive bool `json:"sensitive,omitempty"`
+}
+
+// managedConfigFile is a config file managed by Peridot, with its path relative to the server.
+type managedConfigFile struct {
+	Name      string
+	File      config.ConfigFile
+	Replace   []string // Top-level keys of the merge patch which replace the existing value entirely
+	Sensitive []string // Keys whose values are hidden from the changes
+	Secret    bool     // Whether the whole file is a secret
+}
+
+const hiddenConfigValue = "(hidden)"
+
+// managedConfigFiles returns every config file managed by a server's config, sorted by path.
+func managedConfigFiles(serverConfig config.Config) []managedConfigFile {
+	files := make([]managedConfigFile, 0)
+	if serverConfig.Eula {
+		files = append(files, managedConfigFile{
+			Name: "eula.txt",
+			File: config.ConfigFile{
+				Merge:  map[string]interface{}{"eula": true},
+				Format: utils.ConfigFormatProperties,
+			},
+		})
+	}
+	serverConfigs := map[string]map[string]interface{}{
+		"bukkit.yml":                      serverConfig.BukkitYml,
+		"spigot.yml":                      serverConfig.SpigotYml,
+		"config/paper-global.yml":         serverConfig.PaperGlobal,
+		"config/paper-world-defaults.yml": serverConfig.PaperWorldDefaults,
+	}
+	var sensitive []string
+	if serverConfig.ForwardingProxy != "" && serverConfig.ForwardingSecret != "" {
+		serverConfigs["config/paper-global.yml"] = mergePatches(serverConfig.PaperGlobal, map[string]interface{}{
+			"proxies": map[string]interface{}{
+				"velocity": map[string]interface{}{"enabled": true, "secret": serverConfig.ForwardingSecret},
+			},
+		})
+		sensitive = []string{"proxies.velocity.secret"}
+	}
+	for _, name := range slices.Sorted(maps.Keys(serverConfigs)) {
+		if serverConfigs[name] != nil {
+			files = append(files, managedConfigFile{
+				Name:      filepath.FromSlash(name),
+				File:      config.ConfigFile{Merge: serverConfigs[name], Format: utils.ConfigFormatYAML},
+				Sensitive: sensitive,
+			})
+		}
+	}
+
+	velocityToml := mergePatches(serverConfig.VelocityToml, serverConfig.VelocityServerTables)
+	if serverConfig.Software == "velocity" && serverConfig.ForwardingSecret != "" {
+		secretFile := forwardingSecretFile(serverConfig)
+		velocityToml = mergePatches(velocityToml, map[string]interface{}{
+			"player-info-forwarding-mode": "modern",
+			"forwarding-secret-file":      secretFile,
+		})
+		content := serverConfig.ForwardingSecret
+		files = append(files, managedConfigFile{
+			Name:   filepath.FromSlash(secretFile),
+			File:   config.ConfigFile{Content: &content},
+			Secret: true,
+		})
+	}
+	if len(velocityToml) > 0 {
+		files = append(files, managedConfigFile{
+			Name:    "velocity.toml",
+			File:    config.ConfigFile{Merge: velocityToml, Format: utils.ConfigFormatTOML},
+			Replace: slices.Sorted(maps.Keys(serverConfig.VelocityServerTables)),
+		})
+	}
+	for _, folder := range slices.Sorted(maps.Keys(serverConfig.PluginConfigs)) {
+		for _, name := range slices.Sorted(maps.Keys(serverConfig.PluginConfigs[folder])) {
+			files = append(files, managedConfigFile{
+				Name: filepath.Join("plugins", folder, name),
+				File: serverConfig.PluginConfigs[folder][name],
+			})
+		}
+	}
+	return files
+}
+
+func PrepareAllConfigFileUpdates(
+	configs config.Configs, parallelism int,
+) (map[string][]ConfigFileUpdateOperation, error) {
+	return prepareAll(configs, parallelism, "config file", PrepareConfigFileUpdates,
+		func(operations []ConfigFileUpdateOperation) bool { return len(operations) == 0 })
+}
+
+func PrepareConfigFileUpdates(server string, config config.Config) ([]ConfigFileUpdateOperation, error) {
+	operations := make([]ConfigFileUpdateOperation, 0)
+	for _, managed := range managedConfigFiles(config) {
+		operation, err := prepareConfigFileUpdate(config.Location, managed)
+		if err != nil {
+			return nil, err
+		} else if operation != nil {
+			operations = append(operations, *operation)
+		}
+	}
+	if len(operations) == 0 {
+		return nil, nil
+	}
+	return operations, nil
+}
+
+func prepareConfigFileUpdate(location string, managed managedConfigFile) (*ConfigFileUpdateOperation, error) {
+	path := filepath.Join(location, managed.Name)
+	data, err := os.ReadFile(path)
+	exists := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	operation := &ConfigFileUpdateOperation{
+		Name: managed.Name, Path: path, Sensitive: managed.Secret || len(managed.Sensitive) > 0,
+	}
+	if managed.File.Content != nil {
+		if exists && bytes.Equal(data, []byte(*managed.File.Content)) {
+			return nil, nil
+		}
+		operation.Content = *managed.File.Content
+	} else {
+		format := managed.File.Format
+		if format == "" {
+			if format, err = utils.ConfigFormatFromPath(path); err != nil {
+				return nil, err
+			}
+		}
+		patch := managed.File.Merge
+		if len(managed.Replace) > 0 {
+			current, err := utils.DecodeConfigFile(format, data)
+			if err != nil {
+				return nil, err
+			}
+			patch = replacingPatch(patch, current, managed.Replace)
+		}
+		merged, changes, err := utils.MergeConfigFile(format, data, patch)
+		if err != nil {
+			return nil, err
+		} else if len(changes) == 0 {
+			return nil, nil
+		}
+		operation.Content = string(merged)
+		operation.Changes = hideSensitiveChanges(changes, managed.Sensitive)
+	}
+
+	if exists {
+		if operation.PrevChecksum, err = utils.HashReader(bytes.NewReader(data)); err != nil {
+			return nil, err
+		}
+	}
+	if operation.NewChecksum, err = utils.HashReader(strings.NewReader(operation.Content)); err != nil {
+		return nil, err
+	}
+	return operation, nil
+}
+
+// hideSensitiveChanges hides the values of changes to sensitive keys or objects containing them.
+func hideSensitiveChanges(changes []utils.ConfigChange, sensitive []string) []utils.ConfigChange {
+	for i, change := range changes {
+		if !slices.ContainsFunc(sensitive, func(key string) bool {
+			return key == change.Key || strings.HasPrefix(key, change.Key+".")
+		}) {
+			continue
+		}
+		if change.OldValue != "" {
+			changes[i].OldValue = hiddenConfigValue
+		}
+		if change.NewValue != "" {
+			changes[i].NewValue = hiddenConfigValue
+		}
+	}
+	return changes
+}
+
+// redactSensitiveContent returns a copy of the operations without the content of sensitive files,
+// for saving them to disk. Their checksums are kept, so the content can be checked once recomputed.
+func redactSensitiveContent(operations []ConfigFileUpdateOperation) []ConfigFileUpdateOperation {
+	if !slices.ContainsFunc(operations, func(operation ConfigFileUpdateOperation) bool {
+		return operation.Sensitive
+	}) {
+		return operations
+	}
+	operations = slices.Clone(operations)
+	for i := range operations {
+		if operations[i].Sensitive {
+			operations[i].Content = ""
+		}
+	}
+	return operations
+}
+
+// mergePatches deep merges two merge patches, with the values of override taking precedence.
+func mergePatches(base, override map[string]interface{}) map[string]interface{} {
+	merged := maps.Clone(base)
+	if merged == nil {
+		merged = make(map[string]interface{})
+	}
+	for key, value := range override {
+		baseValue, baseOk := merged[key].(map[string]interface{})
+		overrideValue, overrideOk := value.(map[string]interface{})
+		if baseOk && overrideOk {
+			merged[key] = mergePatches(baseValue, overrideValue)
+		} else {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// replacingPatch returns a copy of patch which deletes every existing key in the given top-level
+// objects which isn't in the patch, so that these objects end up exactly as in the patch.
+func replacingPatch(patch, current map[string]interface{}, keys []string) map[string]interface{} {
+	patch = maps.Clone(patch)
+	for _, key := range keys {
+		object, ok := patch[key].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		object = maps.Clone(object)
+		existing, _ := current[key].(map[string]interface{})
+		for existingKey := range existing {
+			if _, ok := object[existingKey]; !ok {
+				object[existingKey] = nil
+			}
+		}
+		patch[key] = object
+	}
+	return patch
+}
+
+func ApplyConfigFileUpdate(operation ConfigFileUpdateOperation) error {
+	if err := os.MkdirAll(filepath.Dir(operation.Path), 0755); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(operation.Path, []byte(operation.Content), 0644)
+}
@@ -0,0 +1,223 @@
+package deploy
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/mythicmc/peridot/config"
+)
+
+// DecommissionOperation retires a server, archiving its files and config before removing them.
+type DecommissionOperation struct {
+	Server         string `json:"server"`
+	Location       string `json:"location"`
+	LocationExists bool   `json:"location_exists"`
+	ConfigPath     string `json:"config_path"`
+	ArchivePath    string `json:"archive_path"`
+	DeleteLocation bool   `json:"delete_location"`
+}
+
+// ArchiveManifest describes the contents of a decommissioned server's archive. It is written to the
+// archive as manifest.json, after the server's config (config.js) and files (under server/).
+type ArchiveManifest struct {
+	Server    string        `json:"server"`
+	Location  string        `json:"location"`
+	CreatedAt time.Time     `json:"created_at"`
+	Files     []ArchiveFile `json:"files"`
+}
+
+type ArchiveFile struct {
+	Path     string `json:"path"` // Path relative to the server location
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum"`
+}
+
+const (
+	archiveConfigName   = "config.js"
+	archiveManifestName = "manifest.json"
+	archiveServerFolder = "server"
+)
+
+var ErrArchiveExists = errors.New("archive already exists")
+
+func PrepareDecommission(server string, config config.Config, deleteLocation bool) (DecommissionOperation, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return DecommissionOperation{}, err
+	}
+	operation := DecommissionOperation{
+		Server:         server,
+		Location:       config.Location,
+		ConfigPath:     filepath.Join(wd, "configs", server+".js"),
+		ArchivePath:    filepath.Join(wd, "archives", server+"-"+time.Now().UTC().Format("20060102-150405")+".tar.gz"),
+		DeleteLocation: deleteLocation,
+	}
+	if stat, err := os.Stat(config.Location); err == nil && stat.IsDir() {
+		operation.LocationExists = true
+	} else if err != nil && !os.IsNotExist(err) {
+		return DecommissionOperation{}, err
+	}
+	if _, err := os.Stat(operation.ArchivePath); err == nil {
+		return DecommissionOperation{}, ErrArchiveExists
+	}
+	return operation, nil
+}
+
+// ArchiveServer writes the config and files of a server to a gzipped tarball, along with a manifest
+// listing the checksum of every file. The archive is only put in place once it is complete.
+func ArchiveServer(operation DecommissionOperation) (ArchiveManifest, error) {
+	manifest := ArchiveManifest{
+		Server:    operation.Server,
+		Location:  operation.Location,
+		CreatedAt: time.Now().UTC(),
+		Files:     make([]ArchiveFile, 0),
+	}
+	if err := os.MkdirAll(filepath.Dir(operation.ArchivePath), 0755); err != nil {
+		return ArchiveManifest{}, err
+	}
+	file, err := os.CreateTemp(filepath.Dir(operation.ArchivePath), "."+filepath.Base(operation.ArchivePath)+".tmp-")
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+	defer func() {
+		file.Close()
+		os.Remove(file.Name()) // No-op once the archive is renamed
+	}()
+
+	gzipWriter := gzip.NewWriter(file)
+	tarWriter := tar.NewWriter(gzipWriter)
+	if err := archiveFile(tarWriter, operation.ConfigPath, archiveConfigName); err != nil {
+		return ArchiveManifest{}, err
+	}
+	if operation.LocationExists {
+		err := filepath.WalkDir(operation.Location, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(operation.Location, path)
+			if err != nil || rel == "." {
+				return err
+			}
+			name := archiveServerFolder + "/" + filepath.ToSlash(rel)
+			if entry.Type().IsRegular() {
+				archived, err := archiveRegularFile(tarWriter, path, name)
+				if err != nil {
+					return err
+				}
+				archived.Path = filepath.ToSlash(rel)
+				manifest.Files = append(manifest.Files, archived)
+				return nil
+			}
+			return archiveFile(tarWriter, path, name)
+		})
+		if err != nil {
+			return ArchiveManifest{}, err
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return ArchiveManifest{}, err
+	}
+	err = tarWriter.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     archiveManifestName,
+		Size:     int64(len(data)),
+		Mode:     0644,
+		ModTime:  manifest.CreatedAt,
+	})
+	if err != nil {
+		return ArchiveManifest{}, err
+	} else if _, err := tarWriter.Write(data); err != nil {
+		return ArchiveManifest{}, err
+	} else if err := tarWriter.Close(); err != nil {
+		return ArchiveManifest{}, err
+	} else if err := gzipWriter.Close(); err != nil {
+		return ArchiveManifest{}, err
+	} else if err := file.Sync(); err != nil {
+		return ArchiveManifest{}, err
+	} else if err := file.Close(); err != nil {
+		return ArchiveManifest{}, err
+	} else if _, err := os.Stat(operation.ArchivePath); err == nil {
+		return ArchiveManifest{}, ErrArchiveExists
+	} else if err := os.Rename(file.Name(), operation.ArchivePath); err != nil {
+		return ArchiveManifest{}, err
+	}
+	return manifest, nil
+}
+
+// archiveFile writes a folder, symlink or regular file to an archive, skipping other file types.
+func archiveFile(tarWriter *tar.Writer, path, name string) error {
+	stat, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	link := ""
+	switch {
+	case stat.Mode().IsRegular():
+		_, err := archiveRegularFile(tarWriter, path, name)
+		return err
+	case stat.Mode()&fs.ModeSymlink != 0:
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	case stat.IsDir():
+		name += "/"
+	default:
+		return nil // Sockets, devices and pipes can't be restored anyway
+	}
+	header, err := tar.FileInfoHeader(stat, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	return tarWriter.WriteHeader(header)
+}
+
+// archiveRegularFile writes a regular file to an archive, hashing it as it is written.
+func archiveRegularFile(tarWriter *tar.Writer, path, name string) (ArchiveFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+	header, err := tar.FileInfoHeader(stat, "")
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+	header.Name = name
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return ArchiveFile{}, err
+	}
+	hash := sha256.New()
+	// Copy exactly the size in the header, in case the file changes while it is archived
+	if _, err := io.CopyN(io.MultiWriter(tarWriter, hash), file, stat.Size()); err != nil {
+		return ArchiveFile{}, err
+	}
+	return ArchiveFile{Path: name, Size: stat.Size(), Checksum: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// RemoveServer removes the config of an archived server, and its location if requested, so that
+// it is no longer managed or recreated by apply.
+func RemoveServer(operation DecommissionOperation) error {
+	if err := os.Remove(operation.ConfigPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if operation.DeleteLocation && operation.LocationExists {
+		return os.RemoveAll(operation.Location)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package deploy
+
+import (
+	"crypto/rand"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/mythicmc/peridot/config"
+)
+
+const defaultForwardingSecretFile = "forwarding.secret"
+
+// PendingForwardingSecret is the placeholder secret of proxies without a secret file, when loading
+// their state without generating a secret. The actual secret is only generated by apply.
+const PendingForwardingSecret = "(generated on apply)"
+
+type ForwardingRotationError struct{ Proxy, Server string }
+
+func (e ForwardingRotationError) Error() string {
+	return "the forwarding secret of proxy " + e.Proxy + " can only be rotated along with all of its servers, " +
+		"but " + e.Server + " isn't selected"
+}
+
+// ResolveForwardingSecrets sets the modern forwarding secret of every proxy with forwarding enabled
+// and of its backends, read from the proxy's secret file. If the file is missing or empty, a new
+// secret is generated if generate is true, otherwise PendingForwardingSecret is used. This must be
+// done before selecting servers, so that backends get the secret of their proxy even if the proxy
+// isn't selected.
+func ResolveForwardingSecrets(configs config.Configs, generate bool) error {
+	for _, proxy := range forwardingProxies(configs) {
+		data, err := os.ReadFile(filepath.Join(configs[proxy].Location, forwardingSecretFile(configs[proxy])))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		secret := strings.TrimSpace(string(data))
+		if secret == "" && generate {
+			secret = rand.Text()
+		} else if secret == "" {
+			secret = PendingForwardingSecret
+		}
+		setForwardingSecret(configs, proxy, secret)
+	}
+	return nil
+}
+
+// RotateForwardingSecrets generates new modern forwarding secrets for the proxies of the selected
+// servers, setting them in the selected configs. Every proxy whose secret is rotated must be selected
+// along with all of its backends, so that they keep sharing the same secret.
+func RotateForwardingSecrets(configs config.Configs, selected config.Configs) error {
+	for _, proxy := range forwardingProxies(configs) {
+		members := []string{proxy}
+		for _, name := range slices.Sorted(maps.Keys(configs)) {
+			if configs[name].ForwardingProxy == proxy {
+				members = append(members, name)
+			}
+		}
+		if !slices.ContainsFunc(members, func(name string) bool { _, ok := selected[name]; return ok }) {
+			continue
+		}
+		for _, name := range members {
+			if _, ok := selected[name]; !ok {
+				return ForwardingRotationError{Proxy: proxy, Server: name}
+			}
+		}
+		setForwardingSecret(selected, proxy, rand.Text())
+	}
+	return nil
+}
+
+// forwardingProxies returns the sorted names of the proxies with modern forwarding enabled.
+func forwardingProxies(configs config.Configs) []string {
+	proxies := make([]string, 0)
+	for _, name := range slices.Sorted(maps.Keys(configs)) {
+		if velocityServers := configs[name].VelocityServers; velocityServers != nil && velocityServers.Forwarding {
+			proxies = append(proxies, name)
+		}
+	}
+	return proxies
+}
+
+func setForwardingSecret(configs config.Configs, proxy string, secret string) {
+	for name, config := range configs {
+		if name == proxy || config.ForwardingProxy == proxy {
+			config.ForwardingSecret = secret
+			configs[name] = config
+		}
+	}
+}
+
+// forwardingSecretFile returns the path of a proxy's forwarding secret file, relative to the proxy.
+func forwardingSecretFile(config config.Config) string {
+	if path, ok := config.VelocityToml["forwarding-secret-file"].(string); ok && path != "" {
+		return path
+	}
+	return defaultForwardingSecretFile
+}
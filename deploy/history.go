@@ -0,0 +1,190 @@
+package deploy
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mythicmc/peridot/utils"
+)
+
+const pendingDeploymentPrefix = ".pending-"
+
+const deploymentManifestName = "manifest.json"
+
+// Deployment is a set of updates applied to a server, along with backups of every file the updates
+// replaced, stored in the server's history so that the updates can be rolled back.
+type Deployment struct {
+	ID               string                            `json:"id"`
+	CreatedAt        time.Time                         `json:"created_at"`
+	RollbackOf       string                            `json:"rollback_of,omitempty"`
+	Software         *SoftwareUpdateOperation          `json:"software,omitempty"`
+	ServerProperties []ServerPropertiesUpdateOperation `json:"server_properties,omitempty"`
+	Plugins          map[string]PluginUpdateOperation  `json:"plugins,omitempty"`
+	ConfigFiles      []ConfigFileUpdateOperation       `json:"config_files,omitempty"`
+	Files            []DeploymentFile                  `json:"files"`
+	Folders          []string                          `json:"folders,omitempty"` // Created by the deployment, deepest first
+}
+
+type DeploymentFile struct {
+	Path     string `json:"path"`
+	Backup   string `json:"backup"` // Empty if the file didn't exist before the deployment
+	Checksum string `json:"checksum"`
+}
+
+type DeploymentNotFoundError struct{ Server, ID string }
+
+func (e DeploymentNotFoundError) Error() string {
+	return "deployment " + e.ID + " not found in history of server " + e.Server
+}
+
+var ErrEmptyHistory = errors.New("no deployments found in server history")
+
+// HistoryFolder returns the folder storing the deployment history of a server.
+func HistoryFolder(server string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(wd, "history", server), nil
+}
+
+func recordDeployment(dir string, deployment Deployment) (string, error) {
+	historyFolder := filepath.Dir(dir)
+	id := deployment.CreatedAt.Format("20060102-150405")
+	deployment.ID = id
+	for i := 2; ; i++ {
+		if _, err := os.Stat(filepath.Join(historyFolder, deployment.ID)); os.IsNotExist(err) {
+			break
+		} else if err != nil {
+			return "", err
+		}
+		deployment.ID = id + "-" + strconv.Itoa(i)
+	}
+
+	deployment.ConfigFiles = redactSensitiveContent(deployment.ConfigFiles)
+	manifest, err := json.MarshalIndent(deployment, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	err = os.WriteFile(filepath.Join(dir, deploymentManifestName), append(manifest, '\n'), 0600)
+	if err != nil {
+		return "", err
+	}
+	return deployment.ID, os.Rename(dir, filepath.Join(historyFolder, deployment.ID))
+}
+
+// LoadHistory loads the deployment history of a server, from oldest to newest.
+func LoadHistory(server string) ([]Deployment, error) {
+	historyFolder, err := HistoryFolder(server)
+	if err != nil {
+		return nil, err
+	}
+	folders, err := os.ReadDir(historyFolder)
+	if err != nil && os.IsNotExist(err) {
+		return []Deployment{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	history := make([]Deployment, 0, len(folders))
+	for _, folder := range folders {
+		if !folder.IsDir() || strings.HasPrefix(folder.Name(), ".") {
+			continue // Skip pending deployments
+		}
+		manifest, err := os.ReadFile(filepath.Join(historyFolder, folder.Name(), deploymentManifestName))
+		if err != nil {
+			return nil, err
+		}
+		var deployment Deployment
+		if err := json.Unmarshal(manifest, &deployment); err != nil {
+			return nil, err
+		}
+		history = append(history, deployment)
+	}
+	slices.SortFunc(history, func(a, b Deployment) int { return compareDeploymentIDs(a.ID, b.ID) })
+	return history, nil
+}
+
+// compareDeploymentIDs orders deployments by the second they were made in, then by the suffix added
+// to deployments made in the same second, so that 20240101-120000-10 comes after -2.
+func compareDeploymentIDs(a, b string) int {
+	timestampA, suffixA := splitDeploymentID(a)
+	timestampB, suffixB := splitDeploymentID(b)
+	if timestampA != timestampB {
+		return strings.Compare(timestampA, timestampB)
+	} else if suffixA != suffixB {
+		return cmp.Compare(suffixA, suffixB)
+	}
+	return strings.Compare(a, b)
+}
+
+// splitDeploymentID splits an ID into its timestamp and suffix, which is 1 for the first deployment
+// made in a second.
+func splitDeploymentID(id string) (string, int) {
+	if index := strings.LastIndexByte(id, '-'); index > len("20060102") {
+		if suffix, err := strconv.Atoi(id[index+1:]); err == nil {
+			return id[:index], suffix
+		}
+	}
+	return id, 1
+}
+
+// FindDeployment returns the index of the deployment with the given ID in the history, or the
+// latest deployment if the ID is empty.
+func FindDeployment(server string, history []Deployment, id string) (int, error) {
+	if len(history) == 0 {
+		return 0, ErrEmptyHistory
+	} else if id == "" {
+		return len(history) - 1, nil
+	}
+	index := slices.IndexFunc(history, func(deployment Deployment) bool { return deployment.ID == id })
+	if index == -1 {
+		return 0, DeploymentNotFoundError{Server: server, ID: id}
+	}
+	return index, nil
+}
+
+// RestoreDeployment restores every file replaced by a deployment to its state before the deployment,
+// and removes the folders it created if they are empty.
+func RestoreDeployment(server string, deployment Deployment) error {
+	historyFolder, err := HistoryFolder(server)
+	if err != nil {
+		return err
+	}
+	for _, file := range deployment.Files {
+		if file.Backup == "" {
+			if err := os.Remove(file.Path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		} else {
+			backupPath := filepath.Join(historyFolder, deployment.ID, file.Backup)
+			if err := utils.CopyFileVerified(backupPath, file.Path, file.Checksum); err != nil {
+				return err
+			}
+		}
+	}
+	return removeCreatedFolders(deployment.Folders)
+}
+
+// removeCreatedFolders removes folders created by a deployment, deepest first. Folders which are no
+// longer empty, e.g. because the server has run since, are kept.
+func removeCreatedFolders(folders []string) error {
+	for _, folder := range folders {
+		if entries, err := os.ReadDir(folder); err != nil && os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		} else if len(entries) > 0 {
+			continue
+		} else if err := os.Remove(folder); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
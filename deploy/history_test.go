@@ -0,0 +1,97 @@
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestCompareDeploymentIDs(t *testing.T) {
+	ids := []string{
+		"20240101-120000-10",
+		"20240102-080000",
+		"20240101-120000-2",
+		"20231231-235959",
+		"20240101-120000",
+		"20240101-120001",
+		"20240101-120000-3",
+	}
+	want := []string{
+		"20231231-235959",
+		"20240101-120000",
+		"20240101-120000-2",
+		"20240101-120000-3",
+		"20240101-120000-10",
+		"20240101-120001",
+		"20240102-080000",
+	}
+	slices.SortFunc(ids, compareDeploymentIDs)
+	if !slices.Equal(ids, want) {
+		t.Errorf("sorted IDs = %v, want %v", ids, want)
+	}
+}
+
+func TestRestoreDeploymentRemovesCreatedFolders(t *testing.T) {
+	t.Chdir(t.TempDir())
+	location, err := filepath.Abs(filepath.Join("servers", "new"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plugin := filepath.Join(location, "plugins", "Plugin.jar")
+	properties := filepath.Join(location, "server.properties")
+
+	// Deploy a new server, then keep running it for a while
+	transaction, err := BeginTransaction("new", []string{plugin, properties})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{plugin, properties} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(path, []byte("test"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := transaction.Commit(Deployment{}); err != nil {
+		t.Fatal(err)
+	}
+	world := filepath.Join(location, "world", "level.dat")
+	if err := os.MkdirAll(filepath.Dir(world), 0755); err != nil {
+		t.Fatal(err)
+	} else if err := os.WriteFile(world, []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	history, err := LoadHistory("new")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(history) != 1 {
+		t.Fatalf("history has %d deployments, want 1", len(history))
+	}
+	created := []string{filepath.Join(location, "plugins"), location, filepath.Dir(location)}
+	if !slices.Equal(history[0].Folders, created) {
+		t.Errorf("created folders = %v, want %v", history[0].Folders, created)
+	}
+	if err := RestoreDeployment("new", history[0]); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{plugin, properties, filepath.Dir(plugin)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after the rollback", path)
+		}
+	}
+	// Folders which aren't empty are kept
+	if _, err := os.Stat(world); err != nil {
+		t.Errorf("world was removed by the rollback: %v", err)
+	}
+
+	// Without the world, the server's folders are removed entirely
+	if err := os.RemoveAll(filepath.Dir(world)); err != nil {
+		t.Fatal(err)
+	} else if err := RestoreDeployment("new", history[0]); err != nil {
+		t.Fatal(err)
+	} else if _, err := os.Stat(filepath.Dir(location)); !os.IsNotExist(err) {
+		t.Errorf("%s still exists after the rollback", filepath.Dir(location))
+	}
+}
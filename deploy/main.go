@@ -1,5 +1,16 @@
 package deploy
 
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/mythicmc/peridot/config"
+	"github.com/mythicmc/peridot/utils"
+)
+
 type PrepareUpdateError struct {
 	Name string
 	Type string
@@ -8,3 +19,58 @@ type PrepareUpdateError struct {
 func (e PrepareUpdateError) Error() string {
 	return "failed to prepare " + e.Type + " update for " + e.Name
 }
+
+// PrepareErrors are the errors of the servers whose updates failed to be prepared, keyed by server.
+type PrepareErrors map[string]error
+
+func (e PrepareErrors) Error() string {
+	messages := make([]string, 0, len(e))
+	for _, name := range slices.Sorted(maps.Keys(e)) {
+		messages = append(messages, e[name].Error())
+	}
+	return strings.Join(messages, "\n")
+}
+
+// Add adds the errors of the PrepareErrors in err to e, joining the errors of servers failing in both.
+func (e PrepareErrors) Add(err error) {
+	var other PrepareErrors
+	errors.As(err, &other)
+	for name, err := range other {
+		e[name] = errors.Join(e[name], err)
+	}
+}
+
+// prepareAll prepares the updates of every server with at most parallelism servers at once,
+// skipping empty updates. If any server fails, the updates of the other servers are returned along
+// with PrepareErrors.
+func prepareAll[T any](
+	configs config.Configs,
+	parallelism int,
+	updateType string,
+	prepare func(name string, config config.Config) (T, error),
+	isEmpty func(operation T) bool,
+) (map[string]T, error) {
+	names := make([]string, 0, len(configs))
+	for name := range configs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var mutex sync.Mutex
+	operations := make(map[string]T)
+	errs := make(PrepareErrors)
+	utils.RunParallel(names, parallelism, func(name string) {
+		operation, err := prepare(name, configs[name])
+		mutex.Lock()
+		defer mutex.Unlock()
+		if err != nil {
+			errs[name] = errors.Join(PrepareUpdateError{Name: name, Type: updateType}, err)
+		} else if !isEmpty(operation) {
+			operations[name] = operation
+		}
+	})
+	if len(errs) > 0 {
+		return operations, errs
+	}
+	return operations, nil
+}
@@ -0,0 +1,289 @@
+package deploy
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/mythicmc/peridot/config"
+	"github.com/mythicmc/peridot/utils"
+)
+
+const PlanFormatVersion = 2
+
+// Plan is a saved set of update operations, along with the checksums of every file and the listings
+// of every folder the operations were computed from, so that it can be applied later only if nothing
+// has changed in between.
+type Plan struct {
+	FormatVersion int                   `json:"format_version"`
+	CreatedAt     time.Time             `json:"created_at"`
+	Servers       map[string]ServerPlan `json:"servers"`
+}
+
+type ServerPlan struct {
+	Location         string                            `json:"location"`
+	Software         *SoftwareUpdateOperation          `json:"software,omitempty"`
+	ServerProperties []ServerPropertiesUpdateOperation `json:"server_properties,omitempty"`
+	Plugins          map[string]PluginUpdateOperation  `json:"plugins,omitempty"`
+	ConfigFiles      []ConfigFileUpdateOperation       `json:"config_files,omitempty"`
+	Checksums        map[string]string                 `json:"checksums"` // Empty if file is missing
+	Listings         map[string][]string               `json:"listings"`  // Sorted entries, empty if missing
+}
+
+var ErrUnsupportedPlanFormat = errors.New("unsupported plan format version")
+
+type PendingForwardingSecretError struct{ Server string }
+
+func (e PendingForwardingSecretError) Error() string {
+	return "the forwarding secret of server " + e.Server + " doesn't exist yet, and is only generated by apply"
+}
+
+type PlanDriftError struct{ Server, Path string }
+
+func (e PlanDriftError) Error() string {
+	return "plan is stale: " + e.Path + " of server " + e.Server + " has changed since the plan was made"
+}
+
+// NewPlan creates a plan from the given update operations, recording the checksums of their sources.
+func NewPlan(
+	configs config.Configs,
+	softwareUpdates map[string]SoftwareUpdateOperation,
+	serverPropertiesUpdates map[string][]ServerPropertiesUpdateOperation,
+	pluginUpdates map[string]map[string]PluginUpdateOperation,
+	configFileUpdates map[string][]ConfigFileUpdateOperation,
+) (Plan, error) {
+	plan := Plan{
+		FormatVersion: PlanFormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		Servers:       make(map[string]ServerPlan),
+	}
+	getServerPlan := func(server string) ServerPlan {
+		if serverPlan, ok := plan.Servers[server]; ok {
+			return serverPlan
+		}
+		return ServerPlan{
+			Location:  configs[server].Location,
+			Checksums: make(map[string]string),
+			Listings:  make(map[string][]string),
+		}
+	}
+	for server, operation := range softwareUpdates {
+		serverPlan := getServerPlan(server)
+		serverPlan.Software = &operation
+		serverPlan.Checksums[operation.CurrentPath] = ""
+		serverPlan.Checksums[operation.UpdatePath] = ""
+		plan.Servers[server] = serverPlan
+	}
+	for server, operations := range serverPropertiesUpdates {
+		serverPlan := getServerPlan(server)
+		serverPlan.ServerProperties = operations
+		serverPlan.Checksums[filepath.Join(serverPlan.Location, "server.properties")] = ""
+		plan.Servers[server] = serverPlan
+	}
+	for server, operations := range pluginUpdates {
+		serverPlan := getServerPlan(server)
+		serverPlan.Plugins = operations
+		for _, operation := range operations {
+			serverPlan.Checksums[operation.CurrentPath] = ""
+			if operation.UpdatePath != "" {
+				serverPlan.Checksums[operation.UpdatePath] = ""
+			}
+		}
+		plan.Servers[server] = serverPlan
+	}
+	for server, operations := range configFileUpdates {
+		if configs[server].ForwardingSecret == PendingForwardingSecret {
+			return Plan{}, PendingForwardingSecretError{Server: server}
+		}
+		serverPlan := getServerPlan(server)
+		serverPlan.ConfigFiles = redactSensitiveContent(operations)
+		for _, operation := range operations {
+			serverPlan.Checksums[operation.Path] = ""
+		}
+		plan.Servers[server] = serverPlan
+	}
+
+	for _, serverPlan := range plan.Servers {
+		// Plugins are found by listing the plugins folder, so new JARs in it would change the plan
+		serverPlan.Listings[filepath.Join(serverPlan.Location, "plugins")] = nil
+		for path := range serverPlan.Listings {
+			entries, err := listFolderIfExists(path)
+			if err != nil {
+				return Plan{}, err
+			}
+			serverPlan.Listings[path] = entries
+		}
+		for path := range serverPlan.Checksums {
+			checksum, err := hashFileIfExists(path)
+			if err != nil {
+				return Plan{}, err
+			}
+			serverPlan.Checksums[path] = checksum
+		}
+	}
+	return plan, nil
+}
+
+// Verify checks that none of the files and folders the plan was computed from have changed since.
+func (p Plan) Verify() error {
+	if p.FormatVersion != PlanFormatVersion {
+		return ErrUnsupportedPlanFormat
+	}
+	servers := make([]string, 0, len(p.Servers))
+	for server := range p.Servers {
+		servers = append(servers, server)
+	}
+	slices.Sort(servers)
+	for _, server := range servers {
+		serverPlan := p.Servers[server]
+		for _, path := range slices.Sorted(maps.Keys(serverPlan.Listings)) {
+			entries, err := listFolderIfExists(path)
+			if err != nil {
+				return err
+			} else if !slices.Equal(entries, serverPlan.Listings[path]) {
+				return PlanDriftError{Server: server, Path: path}
+			}
+		}
+		paths := make([]string, 0, len(serverPlan.Checksums))
+		for path := range serverPlan.Checksums {
+			paths = append(paths, path)
+		}
+		slices.Sort(paths)
+		for _, path := range paths {
+			checksum, err := hashFileIfExists(path)
+			if err != nil {
+				return err
+			} else if !strings.EqualFold(checksum, serverPlan.Checksums[path]) {
+				return PlanDriftError{Server: server, Path: path}
+			}
+		}
+	}
+	return nil
+}
+
+// ResolveSensitiveContent recomputes the content of the sensitive config files in the plan, which
+// isn't saved with it, from the current configs. The recomputed content must match the plan.
+func (p Plan) ResolveSensitiveContent(configs config.Configs) error {
+	for _, server := range slices.Sorted(maps.Keys(p.Servers)) {
+		var current []ConfigFileUpdateOperation
+		for i, operation := range p.Servers[server].ConfigFiles {
+			if !operation.Sensitive {
+				continue
+			} else if current == nil {
+				serverConfig, ok := configs[server]
+				if !ok {
+					return PlanDriftError{Server: server, Path: operation.Path}
+				}
+				var err error
+				if current, err = PrepareConfigFileUpdates(server, serverConfig); err != nil {
+					return err
+				}
+			}
+			index := slices.IndexFunc(current, func(update ConfigFileUpdateOperation) bool {
+				return update.Path == operation.Path
+			})
+			if index == -1 || !strings.EqualFold(current[index].NewChecksum, operation.NewChecksum) {
+				return PlanDriftError{Server: server, Path: operation.Path}
+			}
+			p.Servers[server].ConfigFiles[i].Content = current[index].Content
+		}
+	}
+	return nil
+}
+
+// HasSensitiveContent checks if the plan has config files whose content wasn't saved with it.
+func (p Plan) HasSensitiveContent() bool {
+	for _, serverPlan := range p.Servers {
+		for _, operation := range serverPlan.ConfigFiles {
+			if operation.Sensitive {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Updates returns the configs and update operations stored in the plan. The returned configs only
+// contain the server locations, as that is all that is needed to apply the operations.
+func (p Plan) Updates() (
+	config.Configs,
+	map[string]SoftwareUpdateOperation,
+	map[string][]ServerPropertiesUpdateOperation,
+	map[string]map[string]PluginUpdateOperation,
+	map[string][]ConfigFileUpdateOperation,
+) {
+	configs := make(config.Configs)
+	softwareUpdates := make(map[string]SoftwareUpdateOperation)
+	serverPropertiesUpdates := make(map[string][]ServerPropertiesUpdateOperation)
+	pluginUpdates := make(map[string]map[string]PluginUpdateOperation)
+	configFileUpdates := make(map[string][]ConfigFileUpdateOperation)
+	for server, serverPlan := range p.Servers {
+		configs[server] = config.Config{Location: serverPlan.Location}
+		if serverPlan.Software != nil {
+			softwareUpdates[server] = *serverPlan.Software
+		}
+		if len(serverPlan.ServerProperties) > 0 {
+			serverPropertiesUpdates[server] = serverPlan.ServerProperties
+		}
+		if len(serverPlan.Plugins) > 0 {
+			pluginUpdates[server] = serverPlan.Plugins
+		}
+		if len(serverPlan.ConfigFiles) > 0 {
+			configFileUpdates[server] = serverPlan.ConfigFiles
+		}
+	}
+	return configs, softwareUpdates, serverPropertiesUpdates, pluginUpdates, configFileUpdates
+}
+
+func LoadPlan(path string) (Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Plan{}, err
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return Plan{}, err
+	} else if plan.FormatVersion != PlanFormatVersion {
+		return Plan{}, ErrUnsupportedPlanFormat
+	}
+	return plan, nil
+}
+
+func SavePlan(path string, plan Plan) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Chmod(path, 0600) // Plans saved over an existing file keep its permissions otherwise
+}
+
+// listFolderIfExists returns the sorted names of the entries of a folder, or nil if it's missing.
+func listFolderIfExists(path string) ([]string, error) {
+	entries, err := os.ReadDir(path)
+	if err != nil && os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name()) // Already sorted by os.ReadDir
+	}
+	return names, nil
+}
+
+func hashFileIfExists(path string) (string, error) {
+	checksum, err := utils.HashFilePath(path)
+	if err != nil && os.IsNotExist(err) {
+		return "", nil
+	}
+	return checksum, err
+}
@@ -0,0 +1,150 @@
+package deploy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mythicmc/peridot/config"
+)
+
+// testPlanServer creates a server with a plugin and server.properties, along with a repository JAR
+// updating the plugin, and returns a plan of these updates.
+func testPlanServer(t *testing.T) (string, Plan) {
+	dir := t.TempDir()
+	location := filepath.Join(dir, "hub")
+	files := map[string]string{
+		filepath.Join(location, "server.properties"):         "server-port=25565\n",
+		filepath.Join(location, "plugins", "LuckPerms.jar"):  "LuckPerms 5.4.100",
+		filepath.Join(dir, "repos", "main", "LuckPerms.jar"): "LuckPerms 5.4.102",
+	}
+	for path, content := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		} else if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	plan, err := NewPlan(
+		config.Configs{"hub": {Location: location}},
+		nil,
+		map[string][]ServerPropertiesUpdateOperation{
+			"hub": {{Property: "server-port", OldValue: "25565", NewValue: "25566"}},
+		},
+		map[string]map[string]PluginUpdateOperation{
+			"hub": {"LuckPerms": {
+				PluginName:  "LuckPerms",
+				CurrentPath: filepath.Join(location, "plugins", "LuckPerms.jar"),
+				UpdatePath:  filepath.Join(dir, "repos", "main", "LuckPerms.jar"),
+				PrevVersion: "5.4.100",
+				NewVersion:  "5.4.102",
+			}},
+		},
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, plan
+}
+
+func TestPlanVerify(t *testing.T) {
+	tests := []struct {
+		name  string
+		edit  func(dir string) error
+		drift string // Path reported as changed, relative to the test folder, empty if the plan is valid
+	}{
+		{"unchanged", func(string) error { return nil }, ""},
+		{
+			"changed server file",
+			func(dir string) error {
+				return os.WriteFile(filepath.Join(dir, "hub", "server.properties"), []byte("server-port=1\n"), 0644)
+			},
+			filepath.Join("hub", "server.properties"),
+		},
+		{
+			"changed repository JAR",
+			func(dir string) error {
+				jar := filepath.Join(dir, "repos", "main", "LuckPerms.jar")
+				return os.WriteFile(jar, []byte("LuckPerms 5.4.103"), 0644)
+			},
+			filepath.Join("repos", "main", "LuckPerms.jar"),
+		},
+		{
+			"new JAR in plugins folder",
+			func(dir string) error {
+				return os.WriteFile(filepath.Join(dir, "hub", "plugins", "Citizens.jar"), []byte("Citizens"), 0644)
+			},
+			filepath.Join("hub", "plugins"),
+		},
+		{
+			"removed JAR from plugins folder",
+			func(dir string) error { return os.Remove(filepath.Join(dir, "hub", "plugins", "LuckPerms.jar")) },
+			filepath.Join("hub", "plugins"),
+		},
+	}
+	for _, test := range tests {
+		dir, plan := testPlanServer(t)
+		if err := test.edit(dir); err != nil {
+			t.Fatal(err)
+		}
+		var want error
+		if test.drift != "" {
+			want = PlanDriftError{Server: "hub", Path: filepath.Join(dir, test.drift)}
+		}
+		if err := plan.Verify(); err != want {
+			t.Errorf("%s: got %v, want %v", test.name, err, want)
+		}
+	}
+
+	_, plan := testPlanServer(t)
+	plan.FormatVersion = PlanFormatVersion - 1
+	if err := plan.Verify(); err != ErrUnsupportedPlanFormat {
+		t.Errorf("older format: got %v, want %v", err, ErrUnsupportedPlanFormat)
+	}
+}
+
+func TestPlanResolveSensitiveContent(t *testing.T) {
+	proxy := config.Config{Location: t.TempDir(), Software: "velocity", ForwardingSecret: "s3cret"}
+	operations, err := PrepareConfigFileUpdates("proxy", proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := config.Configs{"proxy": proxy}
+	secretPath := filepath.Join(proxy.Location, defaultForwardingSecretFile)
+
+	newPlan := func() Plan {
+		plan, err := NewPlan(configs, nil, nil, nil, map[string][]ConfigFileUpdateOperation{"proxy": operations})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plan
+	}
+	plan := newPlan()
+	if !plan.HasSensitiveContent() {
+		t.Fatal("HasSensitiveContent() = false, want true")
+	}
+	for _, operation := range plan.Servers["proxy"].ConfigFiles {
+		if operation.Path == secretPath && operation.Content != "" {
+			t.Errorf("plan saved the content of %s", operation.Path)
+		}
+	}
+	if err := plan.ResolveSensitiveContent(configs); err != nil {
+		t.Fatal(err)
+	}
+	for _, operation := range plan.Servers["proxy"].ConfigFiles {
+		if operation.Path == secretPath && operation.Content != "s3cret" {
+			t.Errorf("resolved content of %s = %q, want %q", operation.Path, operation.Content, "s3cret")
+		}
+	}
+
+	rotated := proxy
+	rotated.ForwardingSecret = "rotated"
+	want := PlanDriftError{Server: "proxy", Path: secretPath}
+	if err := newPlan().ResolveSensitiveContent(config.Configs{"proxy": rotated}); err != want {
+		t.Errorf("changed secret: got %v, want %v", err, want)
+	}
+	if err := newPlan().ResolveSensitiveContent(config.Configs{}); err != want {
+		t.Errorf("missing config: got %v, want %v", err, want)
+	}
+}
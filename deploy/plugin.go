@@ -5,7 +5,6 @@ import (
 	"log"
 	"os"
 	"path/filepath"
-	"slices"
 	"strings"
 
 	"github.com/mythicmc/peridot/config"
@@ -14,66 +13,72 @@ import (
 )
 
 type PluginUpdateOperation struct {
-	PluginName  string
-	CurrentPath string
-	UpdatePath  string
-	PrevVersion string
-	NewVersion  string
+	PluginName  string `json:"plugin_name"`
+	CurrentPath string `json:"current_path"`
+	UpdatePath  string `json:"update_path"`
+	PrevVersion string `json:"prev_version"`
+	NewVersion  string `json:"new_version"`
+	NewChecksum string `json:"new_checksum"`
+	// Minecraft version required by the new plugin's api-version, if newer than the software's
+	RequiresMinecraft string `json:"requires_minecraft,omitempty"`
 }
 
 func PrepareAllPluginUpdates(
-	repos repos.Repositories, configs config.Configs,
+	repos repos.Repositories, configs config.Configs, parallelism int, cache *utils.JarCache,
 ) (map[string]map[string]PluginUpdateOperation, error) {
-	operations := make(map[string]map[string]PluginUpdateOperation)
-	for name, config := range configs {
-		operation, err := PreparePluginUpdates(repos, name, config)
-		if err != nil {
-			return nil, errors.Join(PrepareUpdateError{Name: name, Type: "plugin"}, err)
-		} else if len(operation) > 0 {
-			operations[name] = operation
-		}
-	}
-	return operations, nil
+	return prepareAll(configs, parallelism, "plugin",
+		func(name string, config config.Config) (map[string]PluginUpdateOperation, error) {
+			return PreparePluginUpdates(repos, name, config, cache)
+		},
+		func(operation map[string]PluginUpdateOperation) bool { return len(operation) == 0 })
 }
 
 func PreparePluginUpdates(
-	repositories repos.Repositories, server string, config config.Config,
+	repositories repos.Repositories, server string, config config.Config, cache *utils.JarCache,
 ) (map[string]PluginUpdateOperation, error) {
 	// Get all plugins in server
 	files, err := os.ReadDir(filepath.Join(config.Location, "plugins"))
-	if err != nil {
+	if err != nil && !os.IsNotExist(err) { // New servers have no plugins folder yet
 		return nil, err
 	}
+	// Plugins requiring a newer Minecraft version than the software fail this server only
+	software, _ := findSoftware(repositories, config)
+	for _, requirement := range config.Plugins {
+		plugin, err := findRequiredPlugin(repositories, config, requirement.Name)
+		if err != nil {
+			return nil, err // Config validation should've blocked this
+		} else if err := config.ValidatePluginCompatibility(plugin, software); err != nil {
+			return nil, err
+		}
+	}
 	updates := make(map[string]PluginUpdateOperation)
 	installedPlugins := make(map[string]struct{})
 	for _, file := range files {
 		if file.IsDir() || !strings.HasSuffix(file.Name(), ".jar") {
 			continue
 		}
-		data, err := os.ReadFile(filepath.Join(config.Location, "plugins", file.Name()))
-		if err != nil {
-			return nil, err
-		}
-
 		// Check the plugin version... if we can't determine it, skip this suspicious file
-		_, metadataFile, err := utils.DetermineJarType(data)
-		if err != nil {
-			if errors.Is(err, utils.ErrUnknownJarType) {
-				log.Printf("Warning: %s is not a recognized JAR type, skipping...\n", file.Name())
-				continue
-			}
+		jarInfo, err := cache.Inspect(filepath.Join(config.Location, "plugins", file.Name()))
+		var metadataErr utils.JarMetadataError
+		if errors.Is(err, utils.ErrUnknownJarType) {
+			log.Printf("Warning: %s is not a recognized JAR type, skipping...\n", file.Name())
+			continue
+		} else if errors.As(err, &metadataErr) {
+			log.Printf("Warning: Failed to load plugin metadata from %s, skipping: %v\n", file.Name(), metadataErr.Err)
+			continue
+		} else if err != nil {
 			return nil, err
 		}
-		metadata, err := utils.ParsePluginMetadata(file.Name(), metadataFile)
-		if err != nil {
-			log.Printf("Warning: Failed to load plugin metadata from %s, skipping: %v\n", file.Name(), err)
+		metadata, ok := jarInfo.MetadataFor(config.Software)
+		if !ok {
+			log.Printf("Warning: %s is not a plugin JAR, skipping...\n", file.Name())
 			continue
 		}
-		hash := utils.HashData(data)
+		hash := jarInfo.Checksum
 		installedPlugins[metadata.Name] = struct{}{}
 
 		// If the plugin isn't in the config, remove it
-		if !slices.Contains(config.Plugins, metadata.Name) {
+		if !config.HasPlugin(metadata.Name) {
 			updates[metadata.Name] = PluginUpdateOperation{
 				PluginName:  metadata.Name,
 				CurrentPath: filepath.Join(config.Location, "plugins", file.Name()),
@@ -85,33 +90,38 @@ func PreparePluginUpdates(
 		}
 
 		// If the plugin is out of date, create an update op
-		plugin, err := repositories.GetPlugin(metadata.Name, config.Repos)
+		plugin, err := findRequiredPlugin(repositories, config, metadata.Name)
 		if err != nil {
 			return nil, err // Config validation should've blocked this
-		} else if plugin.Version != metadata.Version || plugin.Checksum != hash {
+		} else if plugin.Checksum != hash {
 			updates[plugin.Name] = PluginUpdateOperation{
-				PluginName:  metadata.Name,
-				CurrentPath: filepath.Join(config.Location, "plugins", file.Name()),
-				UpdatePath:  plugin.Path,
-				PrevVersion: metadata.Version,
-				NewVersion:  plugin.Version,
+				PluginName:        metadata.Name,
+				CurrentPath:       filepath.Join(config.Location, "plugins", file.Name()),
+				UpdatePath:        plugin.Path,
+				PrevVersion:       metadata.Version,
+				NewVersion:        plugin.MetadataFor(config.Software).Version,
+				NewChecksum:       plugin.Checksum,
+				RequiresMinecraft: requiredMinecraftVersion(config, plugin, software),
 			}
 		}
 	}
 
 	// If any plugin is missing, create an update op
-	for _, name := range config.Plugins {
+	for _, requirement := range config.Plugins {
+		name := requirement.Name
 		if _, ok := installedPlugins[name]; !ok {
-			plugin, err := repositories.GetPlugin(name, config.Repos)
+			plugin, err := findRequiredPlugin(repositories, config, name)
 			if err != nil {
 				return nil, err // Config validation should've blocked this
 			} else {
 				updates[name] = PluginUpdateOperation{
-					PluginName:  name,
-					CurrentPath: filepath.Join(config.Location, "plugins", filepath.Base(plugin.Path)),
-					UpdatePath:  plugin.Path,
-					PrevVersion: "",
-					NewVersion:  plugin.Version,
+					PluginName:        name,
+					CurrentPath:       filepath.Join(config.Location, "plugins", filepath.Base(plugin.Path)),
+					UpdatePath:        plugin.Path,
+					PrevVersion:       "",
+					NewVersion:        plugin.MetadataFor(config.Software).Version,
+					NewChecksum:       plugin.Checksum,
+					RequiresMinecraft: requiredMinecraftVersion(config, plugin, software),
 				}
 			}
 		}
@@ -123,6 +133,38 @@ func PreparePluginUpdates(
 	return updates, nil
 }
 
+// findRequiredPlugin returns the locked version of a plugin if the config is locked, else the newest
+// version matching the constraint of the config.
+func findRequiredPlugin(repositories repos.Repositories, config config.Config, name string) (repos.Plugin, error) {
+	if config.Lock != nil {
+		locked, ok := config.Lock.Plugins[name]
+		if plugin, found := repositories.FindPluginChecksum(name, locked.Checksum, locked.Repo); ok && found {
+			return plugin, nil
+		}
+		return repos.Plugin{}, repos.ErrPluginNotInRepos
+	}
+	for _, requirement := range config.Plugins {
+		if requirement.Name != name {
+			continue
+		}
+		constraint, err := requirement.Constraint()
+		if err != nil {
+			return repos.Plugin{}, err
+		}
+		return repositories.FindPlugin(name, constraint, config.Repos)
+	}
+	return repos.Plugin{}, repos.ErrPluginNotInRepos
+}
+
+// requiredMinecraftVersion returns the api-version of a plugin if the software is for an older
+// Minecraft version, even if the config ignores the plugin's compatibility.
+func requiredMinecraftVersion(config config.Config, plugin repos.Plugin, software repos.Software) string {
+	if plugin.CompatibleWith(software) {
+		return ""
+	}
+	return plugin.MetadataFor(config.Software).APIVersion
+}
+
 func ApplyPluginUpdate(operation PluginUpdateOperation) error {
 	if operation.UpdatePath == "" {
 		// Remove plugin
@@ -131,7 +173,11 @@ func ApplyPluginUpdate(operation PluginUpdateOperation) error {
 		}
 	} else {
 		// Add or update plugin
-		if err := utils.CopyFile(operation.UpdatePath, operation.CurrentPath); err != nil {
+		if err := os.MkdirAll(filepath.Dir(operation.CurrentPath), 0755); err != nil {
+			return err
+		}
+		err := utils.CopyFileVerified(operation.UpdatePath, operation.CurrentPath, operation.NewChecksum)
+		if err != nil {
 			return err
 		}
 	}
@@ -1,34 +1,29 @@
 package deploy
 
 import (
-	"errors"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
-	"strings"
 
 	"github.com/mythicmc/peridot/config"
+	"github.com/mythicmc/peridot/utils"
 )
 
 type ServerPropertiesUpdateOperation struct {
-	Property string
-	OldValue string
-	NewValue string
+	Property string `json:"property"`
+	OldValue string `json:"old_value"`
+	NewValue string `json:"new_value"`
+	Added    bool   `json:"added,omitempty"`   // The property is missing from server.properties
+	Removed  bool   `json:"removed,omitempty"` // The property is deleted from server.properties
 }
 
 func PrepareAllServerPropertiesUpdates(
-	configs config.Configs,
+	configs config.Configs, parallelism int,
 ) (map[string][]ServerPropertiesUpdateOperation, error) {
-	operations := make(map[string][]ServerPropertiesUpdateOperation)
-	for name, config := range configs {
-		operation, err := PrepareServerPropertiesUpdates(name, config)
-		if err != nil {
-			return nil, errors.Join(PrepareUpdateError{Name: name, Type: "server_properties"}, err)
-		} else if len(operation) > 0 {
-			operations[name] = operation
-		}
-	}
-	return operations, nil
+	return prepareAll(configs, parallelism, "server_properties", PrepareServerPropertiesUpdates,
+		func(operation []ServerPropertiesUpdateOperation) bool { return len(operation) == 0 })
 }
 
 func PrepareServerPropertiesUpdates(
@@ -38,39 +33,37 @@ func PrepareServerPropertiesUpdates(
 		return nil, nil
 	}
 
-	// Read server's server.properties file
-	serverPropertiesFile, err := os.ReadFile(filepath.Join(config.Location, "server.properties"))
+	serverProperties, exists, err := readServerProperties(config.Location)
 	if err != nil {
 		return nil, err
 	}
-	serverProperties := make(map[string]string)
-	for _, line := range strings.Split(string(serverPropertiesFile), "\n") {
-		line = strings.TrimSpace(line)
-		if line == "" || line[0] == '#' {
-			continue
-		}
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		key := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-		serverProperties[key] = value
+	// New servers get the default properties, so that server.properties is always created
+	properties := config.ServerProperties
+	if !exists {
+		properties = maps.Clone(defaultServerProperties)
+		maps.Copy(properties, config.ServerProperties)
 	}
 
 	operations := make([]ServerPropertiesUpdateOperation, 0)
-	for name, value := range config.ServerProperties {
-		operation := ServerPropertiesUpdateOperation{Property: name, OldValue: serverProperties[name]}
-		if str, ok := value.(string); ok && serverProperties[name] != str {
+	for _, name := range slices.Sorted(maps.Keys(properties)) {
+		value := properties[name]
+		oldValue, exists := serverProperties.Get(name)
+		operation := ServerPropertiesUpdateOperation{Property: name, OldValue: oldValue, Added: !exists}
+		if value == nil {
+			if exists {
+				operation.Removed = true
+				operations = append(operations, operation)
+			}
+		} else if str, ok := value.(string); ok && (!exists || oldValue != str) {
 			operation.NewValue = str
 			operations = append(operations, operation)
 		} else if num, ok := value.(float64); ok {
-			valAsNum, err := strconv.ParseFloat(serverProperties[name], 64)
+			valAsNum, err := strconv.ParseFloat(oldValue, 64)
 			if err != nil || valAsNum != num {
 				operation.NewValue = strconv.FormatFloat(num, 'f', -1, 64)
 				operations = append(operations, operation)
 			}
-		} else if boolVal, ok := value.(bool); ok && serverProperties[name] != strconv.FormatBool(boolVal) {
+		} else if boolVal, ok := value.(bool); ok && oldValue != strconv.FormatBool(boolVal) {
 			operation.NewValue = strconv.FormatBool(boolVal)
 			operations = append(operations, operation)
 		}
@@ -84,28 +77,40 @@ func PrepareServerPropertiesUpdates(
 func ApplyServerPropertiesUpdates(
 	operations []ServerPropertiesUpdateOperation, config config.Config,
 ) error {
-	serverPropertiesFile, err := os.ReadFile(filepath.Join(config.Location, "server.properties"))
+	serverProperties, _, err := readServerProperties(config.Location)
 	if err != nil {
 		return err
 	}
-	serverProperties := string(serverPropertiesFile)
 	for _, operation := range operations {
-		if operation.OldValue == "" {
-			if serverProperties[len(serverProperties)-1] != '\n' {
-				serverProperties += "\n"
-			}
-			serverProperties += operation.Property + "=" + operation.NewValue + "\n"
+		if operation.Removed {
+			serverProperties.Delete(operation.Property)
 		} else {
-			keyVal := operation.Property + "=" + operation.NewValue + "\n"
-			if operation.NewValue == "" {
-				keyVal = ""
-			}
-			serverProperties = strings.ReplaceAll(
-				serverProperties,
-				operation.Property+"="+operation.OldValue+"\n",
-				keyVal,
-			)
+			serverProperties.Set(operation.Property, operation.NewValue)
 		}
 	}
-	return os.WriteFile(filepath.Join(config.Location, "server.properties"), []byte(serverProperties), 0644)
+	if err := os.MkdirAll(config.Location, 0755); err != nil {
+		return err
+	}
+	return utils.WriteFileAtomic(filepath.Join(config.Location, "server.properties"), serverProperties.Bytes(), 0644)
+}
+
+// defaultServerPropertiesHeader starts the server.properties seeded on servers which don't have one
+// yet, such as new servers, as written by the server itself.
+const defaultServerPropertiesHeader = "#Minecraft server properties\n"
+
+// defaultServerProperties are seeded in server.properties on servers which don't have one yet, under
+// the properties of their config. The port is the one proxies register the server with by default,
+// and the server adds every other property with its default value when it first starts.
+var defaultServerProperties = map[string]interface{}{"server-port": "25565"}
+
+// readServerProperties reads a server's server.properties file, or the default one if it's missing,
+// along with whether it exists.
+func readServerProperties(location string) (*utils.Properties, bool, error) {
+	serverPropertiesFile, err := os.ReadFile(filepath.Join(location, "server.properties"))
+	if os.IsNotExist(err) {
+		return utils.ParseProperties([]byte(defaultServerPropertiesHeader)), false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return utils.ParseProperties(serverPropertiesFile), true, nil
 }
@@ -11,39 +11,31 @@ import (
 )
 
 type SoftwareUpdateOperation struct {
-	SoftwareType string
-	CurrentPath  string
-	UpdatePath   string
-	PrevHash     string
-	NewHash      string
+	SoftwareType string `json:"software_type"`
+	CurrentPath  string `json:"current_path"`
+	UpdatePath   string `json:"update_path"`
+	PrevHash     string `json:"prev_hash"`
+	NewHash      string `json:"new_hash"`
+	NewServer    bool   `json:"new_server,omitempty"` // The server's location doesn't exist yet
 }
 
 var ErrSoftwareNotInRepos = errors.New("software not found in repositories")
 
 func PrepareAllSoftwareUpdates(
-	repos repos.Repositories, configs config.Configs,
+	repos repos.Repositories, configs config.Configs, parallelism int, cache *utils.JarCache,
 ) (map[string]SoftwareUpdateOperation, error) {
-	operations := make(map[string]SoftwareUpdateOperation)
-	for name, config := range configs {
-		operation, err := PrepareSoftwareUpdate(repos, name, config)
-		if err != nil {
-			return nil, errors.Join(PrepareUpdateError{Name: name, Type: "software"}, err)
-		} else if operation != (SoftwareUpdateOperation{}) {
-			operations[name] = operation
-		}
-	}
-	return operations, nil
+	return prepareAll(configs, parallelism, "software",
+		func(name string, config config.Config) (SoftwareUpdateOperation, error) {
+			return PrepareSoftwareUpdate(repos, name, config, cache)
+		},
+		func(operation SoftwareUpdateOperation) bool { return operation == (SoftwareUpdateOperation{}) })
 }
 
 func PrepareSoftwareUpdate(
-	repositories repos.Repositories, server string, config config.Config,
+	repositories repos.Repositories, server string, config config.Config, cache *utils.JarCache,
 ) (SoftwareUpdateOperation, error) {
-	var software repos.Software
-	for _, repoName := range config.Repos {
-		repo := repositories[repoName]
-		software = repo.Software[config.Software]
-	}
-	if software.Path == "" {
+	software, ok := findSoftware(repositories, config)
+	if !ok {
 		return SoftwareUpdateOperation{}, ErrSoftwareNotInRepos
 	}
 
@@ -51,15 +43,20 @@ func PrepareSoftwareUpdate(
 		SoftwareType: config.Software,
 		CurrentPath:  filepath.Join(config.Location, config.Software+".jar"),
 		UpdatePath:   software.Path,
-		NewHash:      software.Checksum[:8],
+		NewHash:      software.Checksum,
+	}
+	if _, err := os.Stat(config.Location); os.IsNotExist(err) {
+		operation.NewServer = true
+	} else if err != nil {
+		return SoftwareUpdateOperation{}, err
 	}
-	prevHash, err := utils.HashFilePath(operation.CurrentPath)
+	prevHash, err := cache.Hash(operation.CurrentPath)
 	if err != nil && os.IsNotExist(err) {
 		// Don't set PrevHash, it's already empty
 	} else if err != nil {
 		return SoftwareUpdateOperation{}, err
 	} else {
-		operation.PrevHash = prevHash[:8]
+		operation.PrevHash = prevHash
 	}
 
 	if prevHash == software.Checksum {
@@ -68,8 +65,22 @@ func PrepareSoftwareUpdate(
 	return operation, nil
 }
 
+// findSoftware returns the locked software if the config is locked, else the newest software
+// matching the checksum the config is pinned to, if any.
+func findSoftware(repositories repos.Repositories, config config.Config) (repos.Software, bool) {
+	if config.Lock != nil {
+		locked := config.Lock.Software
+		return repositories.FindSoftware(locked.Type, locked.Checksum, []string{locked.Repo})
+	}
+	return repositories.FindSoftware(config.Software, config.SoftwareChecksum, config.Repos)
+}
+
 func ApplySoftwareUpdate(operation SoftwareUpdateOperation) error {
-	err := utils.CopyFile(operation.UpdatePath, operation.CurrentPath)
+	// Create the server's folder first if it's a new server
+	if err := os.MkdirAll(filepath.Dir(operation.CurrentPath), 0755); err != nil {
+		return err
+	}
+	err := utils.CopyFileVerified(operation.UpdatePath, operation.CurrentPath, operation.NewHash)
 	if err != nil {
 		return err
 	}
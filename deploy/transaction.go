@@ -0,0 +1,147 @@
+package deploy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mythicmc/peridot/config"
+	"github.com/mythicmc/peridot/utils"
+)
+
+// Transaction snapshots every file touched by a server's update operations before they are applied,
+// so that the server can be restored to its previous state if any of the operations fail.
+type Transaction struct {
+	dir     string
+	files   map[string]snapshotFile
+	folders []string // Missing parent folders of the files, deepest first, removed on rollback
+}
+
+type snapshotFile struct {
+	Path     string // Empty if file didn't exist
+	Checksum string
+}
+
+type RollbackError struct {
+	SnapshotDir string
+	Err         error
+}
+
+func (e RollbackError) Error() string {
+	return "failed to roll back, snapshot kept at " + e.SnapshotDir + ": " + e.Err.Error()
+}
+
+func (e RollbackError) Unwrap() error { return e.Err }
+
+// TouchedPaths returns the paths of all files the given update operations may modify.
+func TouchedPaths(
+	config config.Config,
+	softwareUpdate *SoftwareUpdateOperation,
+	serverPropertiesUpdates []ServerPropertiesUpdateOperation,
+	pluginUpdates map[string]PluginUpdateOperation,
+	configFileUpdates []ConfigFileUpdateOperation,
+) []string {
+	paths := make([]string, 0)
+	if softwareUpdate != nil {
+		paths = append(paths, softwareUpdate.CurrentPath)
+	}
+	if len(serverPropertiesUpdates) > 0 {
+		paths = append(paths, filepath.Join(config.Location, "server.properties"))
+	}
+	for _, operation := range pluginUpdates {
+		paths = append(paths, operation.CurrentPath)
+	}
+	for _, operation := range configFileUpdates {
+		paths = append(paths, operation.Path)
+	}
+	return paths
+}
+
+// BeginTransaction snapshots the given files into a pending folder in the server's history, which
+// becomes a deployment in the history once the transaction is committed.
+func BeginTransaction(server string, paths []string) (*Transaction, error) {
+	historyFolder, err := HistoryFolder(server)
+	if err != nil {
+		return nil, err
+	} else if err := os.MkdirAll(historyFolder, 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(historyFolder, pendingDeploymentPrefix)
+	if err != nil {
+		return nil, err
+	}
+	transaction := &Transaction{dir: dir, files: make(map[string]snapshotFile)}
+	for _, path := range paths {
+		if _, ok := transaction.files[path]; ok {
+			continue
+		}
+		name := strconv.Itoa(len(transaction.files)) + "-" + filepath.Base(path)
+		snapshot := snapshotFile{Path: filepath.Join(dir, name)}
+		err := utils.CopyFile(path, snapshot.Path)
+		if err != nil && os.IsNotExist(err) {
+			snapshot.Path = ""
+		} else if err != nil {
+			return nil, errors.Join(err, os.RemoveAll(dir))
+		} else if snapshot.Checksum, err = utils.HashFilePath(snapshot.Path); err != nil {
+			return nil, errors.Join(err, os.RemoveAll(dir))
+		}
+		transaction.files[path] = snapshot
+		for folder := filepath.Dir(path); !slices.Contains(transaction.folders, folder); folder = filepath.Dir(folder) {
+			if _, err := os.Stat(folder); err == nil || !os.IsNotExist(err) {
+				break
+			}
+			transaction.folders = append(transaction.folders, folder)
+		}
+	}
+	// Sort deepest first, so that folders are removed before their parents
+	slices.SortFunc(transaction.folders, func(a, b string) int { return strings.Compare(b, a) })
+	return transaction, nil
+}
+
+// Commit keeps the applied changes, and records the snapshot as a deployment in the server's
+// history, so that the changes can be rolled back later. The ID of the deployment is returned.
+func (t *Transaction) Commit(deployment Deployment) (string, error) {
+	deployment.CreatedAt = time.Now().UTC()
+	deployment.Folders = t.folders
+	deployment.Files = make([]DeploymentFile, 0, len(t.files))
+	for path, snapshot := range t.files {
+		file := DeploymentFile{Path: path, Checksum: snapshot.Checksum}
+		if snapshot.Path != "" {
+			file.Backup = filepath.Base(snapshot.Path)
+		}
+		deployment.Files = append(deployment.Files, file)
+	}
+	slices.SortFunc(deployment.Files, func(a, b DeploymentFile) int { return strings.Compare(a.Path, b.Path) })
+	return recordDeployment(t.dir, deployment)
+}
+
+// Rollback restores every modified file to its snapshotted state, and removes files and folders
+// which didn't exist before, such as the folders of new servers. If restoring fails, the snapshot is
+// kept and a RollbackError is returned.
+func (t *Transaction) Rollback() error {
+	var errs []error
+	for path, snapshot := range t.files {
+		if snapshot.Path == "" {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				errs = append(errs, err)
+			}
+		} else if checksum, _ := utils.HashFilePath(path); checksum == snapshot.Checksum {
+			continue // Unmodified
+		} else if err := utils.CopyFileVerified(snapshot.Path, path, snapshot.Checksum); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		if err := removeCreatedFolders(t.folders); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return RollbackError{SnapshotDir: t.dir, Err: errors.Join(errs...)}
+	}
+	return os.RemoveAll(t.dir)
+}
@@ -3,6 +3,7 @@ module github.com/mythicmc/peridot
 go 1.24.4
 
 require (
+	github.com/BurntSushi/toml v1.5.0
 	github.com/dop251/goja v0.0.0-20250630131328-58d95d85e994
 	github.com/goccy/go-yaml v1.18.0
 )
@@ -2,94 +2,69 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"slices"
+	"strings"
 
 	"github.com/mythicmc/peridot/cmd"
 )
 
+var version = "unknown" // Set at build time with -ldflags="-X main.version=..."
+
 func main() {
-	name := filepath.Base(os.Args[0])
-	args := os.Args[1:]
-	help := false
+	os.Exit(run(filepath.Base(os.Args[0]), os.Args[1:]))
+}
 
-	// TODO: Use the flags package
-	if len(os.Args) < 2 {
-		log.SetFlags(log.Lshortfile)
-		log.Fatalln("no arguments provided")
-	} else if slices.ContainsFunc(os.Args, func(arg string) bool {
-		return arg == "--version" || arg == "-v"
-	}) || os.Args[1] == "version" {
+func run(name string, args []string) int {
+	if len(args) < 1 {
 		log.SetFlags(log.Lshortfile)
-		log.Println("version unknown")
-		return
-	} else if slices.ContainsFunc(os.Args, func(arg string) bool {
-		return arg == "--help" || arg == "-h"
-	}) || os.Args[1] == "help" {
-		help = true
-		if os.Args[1] == "help" && len(args) > 1 {
-			args = args[1:]
-		}
+		log.Println("no arguments provided")
+		printUsage(os.Stderr, name)
+		return cmd.ExitUsage
 	}
 
 	switch args[0] {
-	case "status", "state":
-		if help {
-			fmt.Println("Usage: " + name + " status [server]")
-			fmt.Println("    OR " + name + " state [server]")
-			fmt.Println("")
-			fmt.Println("Show current state of configured Minecraft servers.")
-			fmt.Println("This command compares the current state of the server files on disk with the")
-			fmt.Println("desired state defined in Peridot's configuration.")
-			fmt.Println("If a server is specified, it shows only its state.")
-		} else {
-			cmd.HandleStateCommand(args[1:])
-		}
-		return
-	case "apply":
-		if help {
-			fmt.Println("Usage: " + name + " apply [server]")
-			fmt.Println("")
-			fmt.Println("Apply current config to the Minecraft server files on disk.")
-			fmt.Println("This command will restart the server(s) if possible.")
-			fmt.Println("If a server is specified, apply the config to that server only.")
-		} else {
-			cmd.HandleApplyCommand(args[1:])
-		}
-		return
-	case "apply-live":
-		if help {
-			fmt.Println("Usage: " + name + " apply-live [server]")
-			fmt.Println("")
-			fmt.Println("Apply current config to the Minecraft server files on disk, without restarts.")
-			fmt.Println("If a server is specified, apply the config to that server only.")
-		} else {
-			cmd.HandleApplyLiveCommand(args[1:])
-		}
-		return
-	default:
-		if !help {
+	case "version", "--version", "-v":
+		fmt.Println("peridot version " + version)
+		return cmd.ExitOK
+	case "help", "--help", "-h":
+		if len(args) > 1 {
+			if command, ok := cmd.FindCommand(args[1]); ok {
+				return command.Run(name, []string{"--help"})
+			}
 			log.SetFlags(log.Lshortfile)
-			log.Fatalf("unknown command: %s\n", os.Args[1])
+			log.Printf("unknown command: %s\n", args[1])
+			return cmd.ExitUsage
 		}
+		printUsage(os.Stdout, name)
+		return cmd.ExitOK
 	}
 
-	if help {
-		fmt.Println("Usage: " + name + " (command) [options]")
-		fmt.Println("")
-		fmt.Println("Commands:")
-		fmt.Println("  help                      Show this help message")
-		fmt.Println("  version                   Show version information")
-		fmt.Println("  status, state [server]    Show current state of configured Minecraft servers")
-		fmt.Println("  apply [server]            Apply current config to Minecraft server files")
-		fmt.Println("  apply-live [server]       Apply current config to Minecraft server files,")
-		fmt.Println("                            without restarts")
-		fmt.Println("")
-		fmt.Println("Options:")
-		fmt.Println("  --version, -v            Show version information")
-		fmt.Println("  --help, -h               Show this help message")
-		return
+	command, ok := cmd.FindCommand(args[0])
+	if !ok {
+		log.SetFlags(log.Lshortfile)
+		log.Printf("unknown command: %s\n", args[0])
+		return cmd.ExitUsage
+	}
+	return command.Run(name, args[1:])
+}
+
+func printUsage(out io.Writer, name string) {
+	fmt.Fprintln(out, "Usage: "+name+" (command) [options]")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "Commands:")
+	fmt.Fprintf(out, "  %-26s%s\n", "help [command]", "Show this help message, or help for a command")
+	fmt.Fprintf(out, "  %-26s%s\n", "version", "Show version information")
+	for _, command := range cmd.Commands {
+		names := strings.Join(append([]string{command.Name}, command.Aliases...), ", ")
+		fmt.Fprintf(out, "  %-26s%s\n", names, command.Summary)
 	}
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "Options:")
+	fmt.Fprintln(out, "  --version, -v             Show version information")
+	fmt.Fprintln(out, "  --help, -h                Show this help message")
+	fmt.Fprintln(out, "")
+	fmt.Fprintln(out, "Run '"+name+" help (command)' for the options of a command.")
 }
@@ -5,7 +5,9 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/mythicmc/peridot/utils"
 )
@@ -14,29 +16,47 @@ type Repositories map[string]Repository
 
 type Repository struct {
 	Name     string
-	Software map[string]Software
-	Plugins  map[string]Plugin
+	Software map[string]Software // Newest software of each type
+	Plugins  map[string]Plugin   // Newest version of each plugin
+	// Every software JAR of each type and every version of each plugin, newest first
+	SoftwareVersions map[string][]Software
+	PluginVersions   map[string][]Plugin
 }
 
 type Software struct {
+	Repo      string
 	Type      string
 	Path      string
-	UpdatedAt string
+	UpdatedAt time.Time
 	Checksum  string
+	Version   string // Minecraft version for vanilla and Paper, Velocity version for Velocity
 }
 
 type Plugin struct {
+	Repo     string
 	Name     string
 	Path     string
 	Version  string
 	Checksum string
+	Metadata utils.PluginMetadata // Metadata of the platform the plugin was found by name on
+	// Metadata of every platform supported by the plugin
+	Platforms []utils.PluginMetadata
+}
+
+// MetadataFor returns the metadata of the plugin for the platform loaded by the given software,
+// falling back to the metadata of its other platforms.
+func (p Plugin) MetadataFor(software string) utils.PluginMetadata {
+	if metadata, ok := utils.SelectPluginMetadata(p.Platforms, software); ok {
+		return metadata
+	}
+	return p.Metadata
 }
 
 type RepositoryLoadError struct{ Name string }
 
 func (e RepositoryLoadError) Error() string { return "failed to load repository " + e.Name }
 
-func LoadRepositories() (Repositories, error) {
+func LoadRepositories(cache *utils.JarCache) (Repositories, error) {
 	wd, err := os.Getwd()
 	if err != nil {
 		return nil, err
@@ -55,7 +75,7 @@ func LoadRepositories() (Repositories, error) {
 			continue
 		}
 		repoPath := filepath.Join(repoFolder, folder.Name())
-		repositories[folder.Name()], err = LoadRepository(repoPath, folder.Name())
+		repositories[folder.Name()], err = LoadRepository(repoPath, folder.Name(), cache)
 		if err != nil {
 			return nil, errors.Join(RepositoryLoadError{Name: folder.Name()}, err)
 		}
@@ -63,11 +83,13 @@ func LoadRepositories() (Repositories, error) {
 	return repositories, nil
 }
 
-func LoadRepository(path, name string) (Repository, error) {
+func LoadRepository(path, name string, cache *utils.JarCache) (Repository, error) {
 	repo := Repository{
-		Name:     name,
-		Plugins:  make(map[string]Plugin),
-		Software: make(map[string]Software),
+		Name:             name,
+		Plugins:          make(map[string]Plugin),
+		Software:         make(map[string]Software),
+		PluginVersions:   make(map[string][]Plugin),
+		SoftwareVersions: make(map[string][]Software),
 	}
 	jars, err := os.ReadDir(path)
 	if err != nil {
@@ -78,65 +100,66 @@ func LoadRepository(path, name string) (Repository, error) {
 			continue
 		}
 		jarPath := filepath.Join(path, file.Name())
-		jarData, err := os.ReadFile(jarPath)
-		if err != nil {
-			return repo, err
-		}
-		jarType, metadataFile, err := utils.DetermineJarType(jarData)
-		if err != nil {
-			if errors.Is(err, utils.ErrUnknownJarType) {
-				log.Printf("Warning in repo %s: %s is not a recognized JAR type, skipping...\n", name, jarPath)
-				continue
-			}
+		jarInfo, err := cache.Inspect(jarPath)
+		var metadataErr utils.JarMetadataError
+		if errors.Is(err, utils.ErrUnknownJarType) {
+			log.Printf("Warning in repo %s: %s is not a recognized JAR type, skipping...\n", name, jarPath)
+			continue
+		} else if errors.As(err, &metadataErr) {
+			log.Printf("Warning in repo %s: Failed to load plugin metadata from %s, skipping: %v\n",
+				name, jarPath, metadataErr.Err)
+			continue
+		} else if err != nil {
 			return repo, err
 		}
-		hash := utils.HashData(jarData)
+		jarType, hash := jarInfo.Type, jarInfo.Checksum
 		if jarType == "vanilla" || jarType == "paper" || jarType == "velocity" {
 			stat, err := os.Stat(jarPath)
 			if err != nil {
 				return Repository{}, err
 			}
-			updatedAt := stat.ModTime().String()
-			if existingSoftware, exists := repo.Software[jarType]; exists {
-				if strings.Compare(existingSoftware.UpdatedAt, updatedAt) < 0 {
-					log.Printf("Warning in repo %s: Replacing software %s with timestamp %s with newer timestamp %s\n",
-						name, jarType, existingSoftware.UpdatedAt, updatedAt)
-				} else {
-					log.Printf("Warning in repo %s: Skipping software %s with timestamp %s (already have timestamp %s!)\n",
-						name, jarType, updatedAt, existingSoftware.UpdatedAt)
-					continue
-				}
-			}
-			repo.Software[jarType] = Software{
+			repo.SoftwareVersions[jarType] = append(repo.SoftwareVersions[jarType], Software{
+				Repo:      name,
 				Type:      jarType,
 				Path:      jarPath,
-				UpdatedAt: updatedAt,
+				UpdatedAt: stat.ModTime(),
 				Checksum:  hash,
-			}
+				Version:   jarInfo.Version,
+			})
 		} else {
-			pluginMetadata, err := utils.ParsePluginMetadata(file.Name(), metadataFile)
-			if err != nil {
-				log.Printf("Warning in repo %s: Failed to load plugin metadata from %s, skipping: %v\n",
-					name, jarPath, err)
-				continue
-			}
-			if existingPlugin, exists := repo.Plugins[pluginMetadata.Name]; exists {
-				if strings.Compare(existingPlugin.Version, pluginMetadata.Version) < 0 {
-					log.Printf("Warning in repo %s: Replacing plugin %s with version %s with newer version %s\n",
-						name, pluginMetadata.Name, existingPlugin.Version, pluginMetadata.Version)
-				} else {
-					log.Printf("Warning in repo %s: Skipping plugin %s with version %s (already have version %s!)\n",
-						name, pluginMetadata.Name, pluginMetadata.Version, existingPlugin.Version)
+			// Index plugins by the name of each of their platforms, which usually have the same name
+			for _, pluginMetadata := range jarInfo.Plugins {
+				versions := repo.PluginVersions[pluginMetadata.Name]
+				if slices.ContainsFunc(versions, func(plugin Plugin) bool { return plugin.Path == jarPath }) {
 					continue
 				}
+				repo.PluginVersions[pluginMetadata.Name] = append(versions, Plugin{
+					Repo:      name,
+					Name:      pluginMetadata.Name,
+					Path:      jarPath,
+					Version:   pluginMetadata.Version,
+					Checksum:  hash,
+					Metadata:  pluginMetadata,
+					Platforms: jarInfo.Plugins,
+				})
 			}
-			repo.Plugins[pluginMetadata.Name] = Plugin{
-				Name:     pluginMetadata.Name,
-				Path:     jarPath,
-				Version:  pluginMetadata.Version,
-				Checksum: hash,
+		}
+	}
+
+	// Sort the software by timestamp and the plugins by version, newest first
+	for jarType, software := range repo.SoftwareVersions {
+		slices.SortStableFunc(software, func(a, b Software) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+		repo.Software[jarType] = software[0]
+	}
+	for pluginName, plugins := range repo.PluginVersions {
+		slices.SortStableFunc(plugins, func(a, b Plugin) int { return utils.CompareVersions(b.Version, a.Version) })
+		for i := 1; i < len(plugins); i++ {
+			if utils.CompareVersions(plugins[i-1].Version, plugins[i].Version) == 0 {
+				log.Printf("Warning in repo %s: Plugin %s has multiple JARs with version %s, preferring %s over %s\n",
+					name, pluginName, plugins[i].Version, filepath.Base(plugins[i-1].Path), filepath.Base(plugins[i].Path))
 			}
 		}
+		repo.Plugins[pluginName] = plugins[0]
 	}
 	return repo, nil
 }
@@ -144,18 +167,67 @@ func LoadRepository(path, name string) (Repository, error) {
 var ErrPluginNotInRepos = errors.New("plugin not found in repositories")
 
 func (r Repositories) GetPlugin(name string, repos []string) (Plugin, error) {
-	var plugin Plugin
-	found := false
-	for _, repoName := range repos {
-		if repo, exists := r[repoName]; exists {
-			if p, exists := repo.Plugins[name]; exists {
-				plugin = p
-				found = true
+	return r.FindPlugin(name, nil, repos)
+}
+
+// FindPlugin returns the newest version of a plugin matching a version constraint, from the last
+// of the given repositories which has a matching version.
+func (r Repositories) FindPlugin(name string, constraint utils.VersionConstraint, repos []string) (Plugin, error) {
+	for _, repoName := range slices.Backward(repos) {
+		for _, plugin := range r[repoName].PluginVersions[name] {
+			if constraint.Matches(plugin.Version) {
+				return plugin, nil
 			}
 		}
 	}
-	if found {
-		return plugin, nil
-	}
 	return Plugin{}, ErrPluginNotInRepos
 }
+
+// FindSoftware returns the newest software of a type, from the last of the given repositories
+// which has it. If checksum isn't empty, only the software with this checksum is returned.
+func (r Repositories) FindSoftware(softwareType, checksum string, repos []string) (Software, bool) {
+	for _, repoName := range slices.Backward(repos) {
+		for _, software := range r[repoName].SoftwareVersions[softwareType] {
+			if checksum == "" || strings.EqualFold(software.Checksum, checksum) {
+				return software, true
+			}
+		}
+	}
+	return Software{}, false
+}
+
+// FindPluginChecksum returns the version of a plugin with the given checksum from a repository.
+func (r Repositories) FindPluginChecksum(name, checksum, repo string) (Plugin, bool) {
+	for _, plugin := range r[repo].PluginVersions[name] {
+		if strings.EqualFold(plugin.Checksum, checksum) {
+			return plugin, true
+		}
+	}
+	return Plugin{}, false
+}
+
+// SupportedPlatforms returns the platforms the plugin has metadata for.
+func (p Plugin) SupportedPlatforms() []string {
+	platforms := make([]string, 0, len(p.Platforms))
+	for _, metadata := range p.Platforms {
+		platforms = append(platforms, metadata.Platform)
+	}
+	return platforms
+}
+
+// Supports checks if the plugin can be loaded by the software, on any of the platforms it loads.
+func (p Plugin) Supports(software string) bool {
+	return slices.ContainsFunc(utils.SoftwarePlatforms(software), func(platform string) bool {
+		return slices.Contains(p.SupportedPlatforms(), platform)
+	})
+}
+
+// CompatibleWith checks if a plugin supports the Minecraft version of the software, based on the
+// api-version of the plugin. Plugins are assumed to be compatible if either version is unknown.
+func (p Plugin) CompatibleWith(software Software) bool {
+	apiVersion := p.MetadataFor(software.Type).APIVersion
+	if apiVersion == "" || software.Version == "" || software.Type == "velocity" {
+		return true
+	}
+	return utils.CompareVersions(software.Version, apiVersion) >= 0
+}
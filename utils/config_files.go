@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/goccy/go-yaml"
+)
+
+// Supported formats of config files which can be patched with MergeConfigFile.
+const (
+	ConfigFormatYAML       = "yaml"
+	ConfigFormatJSON       = "json"
+	ConfigFormatTOML       = "toml"
+	ConfigFormatProperties = "properties"
+)
+
+// ConfigChange is a single key changed by a merge patch. Keys of nested values are joined with dots.
+type ConfigChange struct {
+	Key      string `json:"key"`
+	OldValue string `json:"old_value"` // Empty if missing
+	NewValue string `json:"new_value"` // Empty if removed
+}
+
+type UnknownConfigFormatError struct{ Name string }
+
+func (e UnknownConfigFormatError) Error() string {
+	return "unknown config format for " + e.Name + ": must be one of 'yaml', 'json', 'toml' or 'properties'"
+}
+
+type InvalidConfigFileError struct {
+	Format string
+	Err    error
+}
+
+func (e InvalidConfigFileError) Error() string {
+	return "invalid " + e.Format + " config file: " + e.Err.Error()
+}
+
+func (e InvalidConfigFileError) Unwrap() error { return e.Err }
+
+// ConfigFormatFromPath determines the format of a config file from its extension.
+func ConfigFormatFromPath(path string) (string, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yml", ".yaml":
+		return ConfigFormatYAML, nil
+	case ".json":
+		return ConfigFormatJSON, nil
+	case ".toml":
+		return ConfigFormatTOML, nil
+	case ".properties":
+		return ConfigFormatProperties, nil
+	}
+	return "", UnknownConfigFormatError{Name: filepath.Base(path)}
+}
+
+// MergeConfigFile deep merges a patch into the contents of a config file, preserving the order of
+// existing keys and, where the format supports them, comments. Nested objects in the patch are
+// merged recursively, null values delete the key, and any other value (including arrays) replaces
+// the existing value. It returns the new contents and the keys which changed, sorted by key.
+func MergeConfigFile(format string, data []byte, patch map[string]interface{}) ([]byte, []ConfigChange, error) {
+	var merged []byte
+	var changes []ConfigChange
+	var err error
+	switch format {
+	case ConfigFormatYAML:
+		merged, changes, err = mergeYAML(data, patch)
+	case ConfigFormatJSON:
+		merged, changes, err = mergeJSON(data, patch)
+	case ConfigFormatTOML:
+		merged, changes, err = mergeTOML(data, patch)
+	case ConfigFormatProperties:
+		merged, changes, err = mergeProperties(data, patch)
+	default:
+		return nil, nil, UnknownConfigFormatError{Name: format}
+	}
+	if err != nil {
+		return nil, nil, InvalidConfigFileError{Format: format, Err: err}
+	}
+	return merged, changes, nil
+}
+
+// DecodeConfigFile decodes the contents of a config file into a map. Empty files decode to an empty
+// map, and nested keys of .properties files are kept as dotted keys.
+func DecodeConfigFile(format string, data []byte) (map[string]interface{}, error) {
+	values := make(map[string]interface{})
+	var err error
+	switch format {
+	case ConfigFormatYAML:
+		err = yaml.Unmarshal(data, &values)
+	case ConfigFormatJSON:
+		if len(bytes.TrimSpace(data)) != 0 {
+			err = json.Unmarshal(data, &values)
+		}
+	case ConfigFormatTOML:
+		_, err = toml.Decode(string(data), &values)
+	case ConfigFormatProperties:
+		for key, value := range parsePropertiesValues(data) {
+			values[key] = value
+		}
+	default:
+		return nil, UnknownConfigFormatError{Name: format}
+	}
+	if err != nil {
+		return nil, InvalidConfigFileError{Format: format, Err: err}
+	} else if values == nil {
+		values = make(map[string]interface{}) // YAML documents with only comments decode to nil
+	}
+	return values, nil
+}
+
+// FormatConfigValue formats a config value for display, with strings shown as-is and other values
+// shown as JSON. Missing values are shown as an empty string.
+func FormatConfigValue(value interface{}) string {
+	if value == nil {
+		return ""
+	} else if str, ok := value.(string); ok {
+		return str
+	}
+	data, err := json.Marshal(normalizeConfigValue(value))
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// configValuesEqual compares config values decoded from different formats, ignoring the differences
+// between their number types.
+func configValuesEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(normalizeConfigValue(a), normalizeConfigValue(b))
+}
+
+// normalizeConfigValue converts a decoded config value to the types used by encoding/json.
+func normalizeConfigValue(value interface{}) interface{} {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(data, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
+
+// withoutNullValues returns a copy of a patch value without null values, for keys that don't exist.
+func withoutNullValues(value interface{}) interface{} {
+	patch, ok := value.(map[string]interface{})
+	if !ok {
+		return value
+	}
+	result := make(map[string]interface{}, len(patch))
+	for key, value := range patch {
+		if value != nil {
+			result[key] = withoutNullValues(value)
+		}
+	}
+	return result
+}
+
+// sortedPatchKeys returns the keys of a patch in a deterministic order.
+func sortedPatchKeys(patch map[string]interface{}) []string {
+	return slices.Sorted(maps.Keys(patch))
+}
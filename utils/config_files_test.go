@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+)
+
+type configMergeTest struct {
+	name    string
+	input   string
+	patch   map[string]interface{}
+	want    string
+	changes []ConfigChange
+}
+
+// testConfigMerges merges the patch of each test into its input, checking the merged file, the
+// changes, and that merging the patch again changes nothing.
+func testConfigMerges(t *testing.T, format string, tests []configMergeTest) {
+	t.Helper()
+	for _, test := range tests {
+		got, changes, err := MergeConfigFile(format, []byte(test.input), test.patch)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+		if !slices.Equal(changes, test.changes) {
+			t.Errorf("%s: changes = %v, want %v", test.name, changes, test.changes)
+		}
+		again, changes, err := MergeConfigFile(format, got, test.patch)
+		if err != nil {
+			t.Errorf("%s: merging again: %v", test.name, err)
+		} else if len(changes) != 0 || string(again) != string(got) {
+			t.Errorf("%s: merging again changed %v, got %q", test.name, changes, again)
+		}
+	}
+}
+
+func TestConfigFormatFromPath(t *testing.T) {
+	tests := map[string]string{
+		"config.yml":              ConfigFormatYAML,
+		"plugins/x/Config.YAML":   ConfigFormatYAML,
+		"velocity.toml":           ConfigFormatTOML,
+		"config.json":             ConfigFormatJSON,
+		"server.properties":       ConfigFormatProperties,
+		"plugins/x/messages.conf": "",
+		"plugins/x/no-extension":  "",
+	}
+	for path, want := range tests {
+		if got, err := ConfigFormatFromPath(path); got != want || (err == nil) != (want != "") {
+			t.Errorf("ConfigFormatFromPath(%q) = %q, %v, want %q", path, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+)
+
+var errJSONNotObject = errors.New("top-level value is not an object")
+
+// jsonObject is a JSON object which remembers the order of its keys.
+type jsonObject struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// mergeJSON merges a patch into a JSON document, keeping the order of its keys and its indentation.
+func mergeJSON(data []byte, patch map[string]interface{}) ([]byte, []ConfigChange, error) {
+	object := &jsonObject{values: make(map[string]interface{})}
+	if len(bytes.TrimSpace(data)) != 0 {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		value, err := decodeJSONValue(decoder)
+		if err != nil {
+			return nil, nil, err
+		} else if _, err := decoder.Token(); err != io.EOF {
+			return nil, nil, errors.New("unexpected data after top-level value")
+		}
+		var ok bool
+		if object, ok = value.(*jsonObject); !ok {
+			return nil, nil, errJSONNotObject
+		}
+	}
+
+	changes := make([]ConfigChange, 0)
+	mergeJSONObject(object, patch, "", &changes)
+	if len(changes) == 0 {
+		return data, changes, nil
+	}
+	var buf bytes.Buffer
+	if err := encodeJSONValue(&buf, object, jsonIndent(data), ""); err != nil {
+		return nil, nil, err
+	}
+	buf.WriteByte('\n')
+	if bytes.Contains(data, []byte("\r\n")) {
+		return bytes.ReplaceAll(buf.Bytes(), []byte("\n"), []byte("\r\n")), changes, nil
+	}
+	return buf.Bytes(), changes, nil
+}
+
+func mergeJSONObject(object *jsonObject, patch map[string]interface{}, prefix string, changes *[]ConfigChange) {
+	for _, key := range sortedPatchKeys(patch) {
+		value := patch[key]
+		oldValue, exists := object.values[key]
+		if subPatch, ok := value.(map[string]interface{}); ok {
+			if subObject, ok := oldValue.(*jsonObject); ok {
+				mergeJSONObject(subObject, subPatch, prefix+key+".", changes)
+				continue
+			}
+		}
+		if value == nil {
+			if exists {
+				object.keys = slices.DeleteFunc(object.keys, func(k string) bool { return k == key })
+				delete(object.values, key)
+				*changes = append(*changes, ConfigChange{Key: prefix + key, OldValue: FormatConfigValue(oldValue)})
+			}
+			continue
+		}
+		value = withoutNullValues(value)
+		if exists && configValuesEqual(oldValue, value) {
+			continue
+		} else if !exists {
+			object.keys = append(object.keys, key)
+		}
+		object.values[key] = value
+		*changes = append(*changes, ConfigChange{
+			Key:      prefix + key,
+			OldValue: FormatConfigValue(oldValue),
+			NewValue: FormatConfigValue(value),
+		})
+	}
+}
+
+func (o *jsonObject) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	err := encodeJSONValue(&buf, o, "", "")
+	return buf.Bytes(), err
+}
+
+func decodeJSONValue(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		object := &jsonObject{values: make(map[string]interface{})}
+		for decoder.More() {
+			keyToken, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			key := keyToken.(string)
+			value, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := object.values[key]; !ok {
+				object.keys = append(object.keys, key)
+			}
+			object.values[key] = value
+		}
+		_, err := decoder.Token()
+		return object, err
+	case json.Delim('['):
+		array := make([]interface{}, 0)
+		for decoder.More() {
+			value, err := decodeJSONValue(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		_, err := decoder.Token()
+		return array, err
+	}
+	return token, nil
+}
+
+// encodeJSONValue encodes a value like json.MarshalIndent, but keeps the key order of jsonObjects.
+func encodeJSONValue(buf *bytes.Buffer, value interface{}, indent, prefix string) error {
+	newline := func(prefix string) {
+		if indent != "" {
+			buf.WriteString("\n" + prefix)
+		}
+	}
+	separator := ":"
+	if indent != "" {
+		separator = ": "
+	}
+	switch value := value.(type) {
+	case *jsonObject:
+		if len(value.keys) == 0 {
+			buf.WriteString("{}")
+			return nil
+		}
+		buf.WriteByte('{')
+		for i, key := range value.keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(prefix + indent)
+			encodedKey, _ := json.Marshal(key)
+			buf.Write(encodedKey)
+			buf.WriteString(separator)
+			if err := encodeJSONValue(buf, value.values[key], indent, prefix+indent); err != nil {
+				return err
+			}
+		}
+		newline(prefix)
+		buf.WriteByte('}')
+	case []interface{}:
+		if len(value) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteByte('[')
+		for i, item := range value {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			newline(prefix + indent)
+			if err := encodeJSONValue(buf, item, indent, prefix+indent); err != nil {
+				return err
+			}
+		}
+		newline(prefix)
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		object := &jsonObject{keys: sortedPatchKeys(value), values: value}
+		return encodeJSONValue(buf, object, indent, prefix)
+	default:
+		encoder := json.NewEncoder(buf)
+		encoder.SetEscapeHTML(false)
+		if err := encoder.Encode(value); err != nil {
+			return err
+		}
+		buf.Truncate(buf.Len() - 1) // Drop the newline added by Encode
+	}
+	return nil
+}
+
+// jsonIndent detects the indentation of a JSON document from its first indented line, defaulting to
+// 2 spaces. Documents on a single line are kept on a single line.
+func jsonIndent(data []byte) string {
+	trimmed := bytes.TrimSpace(data)
+	if len(trimmed) == 0 {
+		return "  "
+	} else if !bytes.ContainsRune(trimmed, '\n') {
+		return ""
+	}
+	for _, line := range strings.Split(string(trimmed), "\n")[1:] {
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if indent != "" {
+			return indent
+		}
+	}
+	return "  "
+}
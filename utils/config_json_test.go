@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMergeJSON(t *testing.T) {
+	testConfigMerges(t, ConfigFormatJSON, []configMergeTest{
+		{
+			"keeps order and indentation",
+			"{\n\t\"z\": 1,\n\t\"a\": {\n\t\t\"port\": 25565,\n\t\t\"debug\": false\n\t}\n}\n",
+			map[string]interface{}{"a": map[string]interface{}{"debug": true, "motd": "<Hi>"}},
+			"{\n\t\"z\": 1,\n\t\"a\": {\n\t\t\"port\": 25565,\n\t\t\"debug\": true,\n\t\t\"motd\": \"<Hi>\"\n\t}\n}\n",
+			[]ConfigChange{{Key: "a.debug", OldValue: "false", NewValue: "true"}, {Key: "a.motd", NewValue: "<Hi>"}},
+		},
+		{
+			"null deletes keys",
+			"{\n  \"a\": 1,\n  \"b\": {\"c\": 2, \"d\": 3}\n}\n",
+			map[string]interface{}{"a": nil, "b": map[string]interface{}{"c": nil}, "missing": nil},
+			"{\n  \"b\": {\n    \"d\": 3\n  }\n}\n",
+			[]ConfigChange{{Key: "a", OldValue: "1"}, {Key: "b.c", OldValue: "2"}},
+		},
+		{
+			"replaces arrays and scalars with objects",
+			"{\"list\": [1, 2], \"value\": 1}",
+			map[string]interface{}{"list": []interface{}{3.0}, "value": map[string]interface{}{"x": 1.5, "y": nil}},
+			"{\"list\":[3],\"value\":{\"x\":1.5}}\n",
+			[]ConfigChange{{Key: "list", OldValue: "[1,2]", NewValue: "[3]"}, {Key: "value", OldValue: "1", NewValue: `{"x":1.5}`}},
+		},
+		{
+			"keeps large numbers",
+			"{\n  \"id\": 12345678901234567890,\n  \"a\": 1\n}\n",
+			map[string]interface{}{"a": 2.0},
+			"{\n  \"id\": 12345678901234567890,\n  \"a\": 2\n}\n",
+			[]ConfigChange{{Key: "a", OldValue: "1", NewValue: "2"}},
+		},
+		{
+			"keeps crlf",
+			"{\r\n  \"a\": 1\r\n}\r\n",
+			map[string]interface{}{"b": "c"},
+			"{\r\n  \"a\": 1,\r\n  \"b\": \"c\"\r\n}\r\n",
+			[]ConfigChange{{Key: "b", NewValue: "c"}},
+		},
+		{
+			"empty file",
+			"",
+			map[string]interface{}{"a": map[string]interface{}{"b": true, "c": nil}},
+			"{\n  \"a\": {\n    \"b\": true\n  }\n}\n",
+			[]ConfigChange{{Key: "a", NewValue: `{"b":true}`}},
+		},
+		{
+			"unchanged",
+			"{ \"a\" : 1.0 }",
+			map[string]interface{}{"a": 1.0, "b": nil},
+			"{ \"a\" : 1.0 }",
+			[]ConfigChange{},
+		},
+	})
+}
+
+func TestMergeJSONErrors(t *testing.T) {
+	for _, input := range []string{"[1, 2]", "{\"a\": 1} {}", "{\"a\": "} {
+		if _, _, err := MergeConfigFile(ConfigFormatJSON, []byte(input), map[string]interface{}{"a": 2.0}); err == nil {
+			t.Errorf("merging into %q succeeded", input)
+		} else if !errors.As(err, new(InvalidConfigFileError)) {
+			t.Errorf("merging into %q = %v, want an InvalidConfigFileError", input, err)
+		}
+	}
+}
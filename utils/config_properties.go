@@ -0,0 +1,67 @@
+package utils
+
+// mergeProperties merges a patch into a .properties file. Nested objects in the patch are flattened
+// into dotted keys, as is conventional for .properties files.
+func mergeProperties(data []byte, patch map[string]interface{}) ([]byte, []ConfigChange, error) {
+	properties := ParseProperties(data)
+	changes := make([]ConfigChange, 0)
+	for _, key := range flattenPropertiesPatchKeys(patch, "") {
+		value := lookupPropertiesPatch(patch, key)
+		oldValue, exists := properties.Get(key)
+		if value == nil {
+			if properties.Delete(key) {
+				changes = append(changes, ConfigChange{Key: key, OldValue: oldValue})
+			}
+			continue
+		}
+		newValue := FormatConfigValue(value)
+		if exists && oldValue == newValue {
+			continue
+		}
+		properties.Set(key, newValue)
+		changes = append(changes, ConfigChange{Key: key, OldValue: oldValue, NewValue: newValue})
+	}
+	if len(changes) == 0 {
+		return data, changes, nil
+	}
+	return properties.Bytes(), changes, nil
+}
+
+// parsePropertiesValues returns the values of every key in a .properties file.
+func parsePropertiesValues(data []byte) map[string]string {
+	properties := ParseProperties(data)
+	values := make(map[string]string)
+	for _, key := range properties.Keys() {
+		values[key], _ = properties.Get(key)
+	}
+	return values
+}
+
+// flattenPropertiesPatchKeys returns the dotted keys of every non-object value in a patch.
+func flattenPropertiesPatchKeys(patch map[string]interface{}, prefix string) []string {
+	keys := make([]string, 0, len(patch))
+	for _, key := range sortedPatchKeys(patch) {
+		if subPatch, ok := patch[key].(map[string]interface{}); ok {
+			keys = append(keys, flattenPropertiesPatchKeys(subPatch, prefix+key+".")...)
+		} else {
+			keys = append(keys, prefix+key)
+		}
+	}
+	return keys
+}
+
+// lookupPropertiesPatch returns the value of a dotted key returned by flattenPropertiesPatchKeys.
+func lookupPropertiesPatch(patch map[string]interface{}, key string) interface{} {
+	if value, ok := patch[key]; ok {
+		return value
+	}
+	for prefix, subPatch := range patch {
+		if subPatch, ok := subPatch.(map[string]interface{}); ok && len(key) > len(prefix) &&
+			key[:len(prefix)+1] == prefix+"." {
+			if value := lookupPropertiesPatch(subPatch, key[len(prefix)+1:]); value != nil {
+				return value
+			}
+		}
+	}
+	return nil
+}
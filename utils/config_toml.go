@@ -0,0 +1,533 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+type UnsupportedTOMLPatchError struct{ Key string }
+
+func (e UnsupportedTOMLPatchError) Error() string {
+	return "cannot patch " + e.Key + ": it is defined in an array of tables or an inline table"
+}
+
+// tomlDocument is a TOML document split into lines, along with the location of every table header
+// and key/value pair, so that it can be edited without touching the rest of the document.
+type tomlDocument struct {
+	lines   []string
+	headers []tomlHeader
+	entries []tomlEntry
+}
+
+type tomlHeader struct {
+	path  []string
+	array bool
+	line  int
+}
+
+type tomlEntry struct {
+	path       []string // Full path of the key, including its table
+	table      []string // Path of the table header the key is under
+	keyText    string   // Key as written in the document, e.g. `"a".b`
+	indent     string
+	comment    string // Trailing comment on the last line of the value
+	start, end int    // Lines of the key/value pair, inclusive
+}
+
+// mergeTOML merges a patch into a TOML document. Only the lines of changed keys are rewritten, so
+// comments and formatting elsewhere are kept. New keys are added at the end of their table.
+func mergeTOML(data []byte, patch map[string]interface{}) ([]byte, []ConfigChange, error) {
+	var current map[string]interface{}
+	if _, err := toml.Decode(string(data), &current); err != nil {
+		return nil, nil, err
+	}
+	text := string(data)
+	crlf := strings.Contains(text, "\r\n")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+	if text == "" {
+		lines = []string{}
+	}
+
+	changes := make([]ConfigChange, 0)
+	lines, err := mergeTOMLTable(lines, current, patch, nil, &changes)
+	if err != nil {
+		return nil, nil, err
+	} else if len(changes) == 0 {
+		return data, changes, nil
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1] // Drop blank lines left at the end by removed tables
+	}
+	merged := strings.Join(lines, "\n") + "\n"
+	if _, err := toml.Decode(merged, new(map[string]interface{})); err != nil {
+		return nil, nil, fmt.Errorf("patch produced invalid TOML: %w", err)
+	}
+	if crlf {
+		merged = strings.ReplaceAll(merged, "\n", "\r\n")
+	}
+	return []byte(merged), changes, nil
+}
+
+func mergeTOMLTable(
+	lines []string, current, patch map[string]interface{}, path []string, changes *[]ConfigChange,
+) ([]string, error) {
+	for _, key := range sortedPatchKeys(patch) {
+		value := patch[key]
+		keyPath := append(slices.Clone(path), key)
+		name := strings.Join(keyPath, ".")
+		doc, err := parseTOMLDocument(lines)
+		if err != nil {
+			return nil, err
+		}
+		entry := doc.findEntry(keyPath)
+		oldValue, exists := current[key]
+
+		if subPatch, ok := value.(map[string]interface{}); ok {
+			if subTable, ok := oldValue.(map[string]interface{}); ok && entry == nil {
+				if lines, err = mergeTOMLTable(lines, subTable, subPatch, keyPath, changes); err != nil {
+					return nil, err
+				}
+				continue
+			}
+		}
+		if exists && entry == nil && !doc.hasTable(keyPath) {
+			return nil, UnsupportedTOMLPatchError{Key: name}
+		}
+		if value == nil {
+			if exists {
+				lines = doc.remove(keyPath)
+				*changes = append(*changes, ConfigChange{Key: name, OldValue: FormatConfigValue(oldValue)})
+			}
+			continue
+		}
+		value = withoutNullValues(value)
+		if exists && configValuesEqual(oldValue, value) {
+			continue
+		}
+
+		if entry != nil && !isTOMLTable(value) {
+			encoded, err := encodeTOMLValue(value)
+			if err != nil {
+				return nil, err
+			}
+			line := entry.indent + entry.keyText + " = " + encoded + entry.comment
+			lines = slices.Replace(doc.lines, entry.start, entry.end+1, line)
+		} else {
+			if exists {
+				doc, err = parseTOMLDocument(doc.remove(keyPath))
+				if err != nil {
+					return nil, err
+				}
+			}
+			if lines, err = doc.insert(path, key, value); err != nil {
+				return nil, err
+			}
+		}
+		*changes = append(*changes, ConfigChange{
+			Key:      name,
+			OldValue: FormatConfigValue(oldValue),
+			NewValue: FormatConfigValue(value),
+		})
+	}
+	return lines, nil
+}
+
+func (d *tomlDocument) findEntry(path []string) *tomlEntry {
+	for i := range d.entries {
+		if slices.Equal(d.entries[i].path, path) {
+			return &d.entries[i]
+		}
+	}
+	return nil
+}
+
+// hasTable checks if a table is defined by a header or by dotted keys, and isn't an array of tables.
+func (d *tomlDocument) hasTable(path []string) bool {
+	if slices.ContainsFunc(d.headers, func(header tomlHeader) bool {
+		return header.array && slices.Equal(header.path, path)
+	}) {
+		return false
+	}
+	for _, header := range d.headers {
+		if hasTOMLPathPrefix(header.path, path) && !(header.array && len(header.path) == len(path)) {
+			return true
+		}
+	}
+	for _, entry := range d.entries {
+		if len(entry.path) > len(path) && hasTOMLPathPrefix(entry.path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// remove returns the lines of the document without the key at path, and if it is a table, without
+// the headers and keys of the table and its subtables.
+func (d *tomlDocument) remove(path []string) []string {
+	removed := make([]bool, len(d.lines))
+	for _, entry := range d.entries {
+		if hasTOMLPathPrefix(entry.path, path) {
+			for i := entry.start; i <= entry.end; i++ {
+				removed[i] = true
+			}
+		}
+	}
+	for _, header := range d.headers {
+		if hasTOMLPathPrefix(header.path, path) {
+			removed[header.line] = true
+		}
+	}
+	lines := make([]string, 0, len(d.lines))
+	for i, line := range d.lines {
+		if !removed[i] {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// insert returns the lines of the document with a new key added to the table at path. Tables are
+// added as new sections at the end of the document.
+func (d *tomlDocument) insert(path []string, key string, value interface{}) ([]string, error) {
+	if table, ok := value.(map[string]interface{}); ok && isTOMLTable(value) {
+		section := encodeTOMLSection(append(slices.Clone(path), key), table)
+		lines := slices.Clone(d.lines)
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		return append(lines, section...), nil
+	}
+	encoded, err := encodeTOMLValue(value)
+	if err != nil {
+		return nil, err
+	}
+	line := encodeTOMLKey(key) + " = " + encoded
+
+	// Find the end of the table's own keys, skipping trailing blank lines and comments
+	start := -1
+	if len(path) == 0 {
+		start = 0
+	}
+	for _, header := range d.headers {
+		if !header.array && slices.Equal(header.path, path) {
+			start = header.line + 1
+		}
+	}
+	if start == -1 {
+		// Add the key next to the dotted keys defining the table, if any, as a table can't be
+		// defined by both dotted keys and a header
+		var last *tomlEntry
+		for i, entry := range d.entries {
+			if len(entry.path) > len(path) && hasTOMLPathPrefix(entry.path, path) && len(entry.table) < len(path) {
+				last = &d.entries[i]
+			}
+		}
+		if last != nil {
+			keyPath := append(slices.Clone(path[len(last.table):]), key)
+			for i, part := range keyPath {
+				keyPath[i] = encodeTOMLKey(part)
+			}
+			line := last.indent + strings.Join(keyPath, ".") + " = " + encoded
+			return slices.Insert(slices.Clone(d.lines), last.end+1, line), nil
+		}
+		// The table has no header of its own, so add one with the key at the end of the document
+		section := encodeTOMLSection(path, map[string]interface{}{key: value})
+		lines := slices.Clone(d.lines)
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		return append(lines, section...), nil
+	}
+	end := len(d.lines)
+	for _, header := range d.headers {
+		if header.line >= start && header.line < end {
+			end = header.line
+		}
+	}
+	insertAt := start
+	for _, entry := range d.entries {
+		if entry.start >= start && entry.end < end && entry.end+1 > insertAt {
+			insertAt = entry.end + 1
+		}
+	}
+	if insertAt == start && start == 0 && end > 0 {
+		// No top-level keys yet, add it before the first table, after any leading comments
+		for insertAt < end && strings.HasPrefix(strings.TrimSpace(d.lines[insertAt]), "#") {
+			insertAt++
+		}
+	}
+	return slices.Insert(slices.Clone(d.lines), insertAt, line), nil
+}
+
+func hasTOMLPathPrefix(path, prefix []string) bool {
+	return len(path) >= len(prefix) && slices.Equal(path[:len(prefix)], prefix)
+}
+
+// isTOMLTable checks if a value should be written as a table section instead of an inline value.
+func isTOMLTable(value interface{}) bool {
+	table, ok := value.(map[string]interface{})
+	return ok && len(table) > 0
+}
+
+func parseTOMLDocument(lines []string) (*tomlDocument, error) {
+	doc := &tomlDocument{lines: lines}
+	var table []string
+	for i := 0; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		} else if trimmed[0] == '[' {
+			header := tomlHeader{line: i, array: strings.HasPrefix(trimmed, "[[")}
+			rest := strings.TrimLeft(trimmed, "[")
+			path, rest, err := parseTOMLKey(rest)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", i+1, err)
+			} else if !strings.HasPrefix(strings.TrimSpace(rest), "]") {
+				return nil, fmt.Errorf("line %d: invalid table header", i+1)
+			}
+			header.path = path
+			doc.headers = append(doc.headers, header)
+			table = path
+			continue
+		}
+
+		indent := lines[i][:len(lines[i])-len(strings.TrimLeft(lines[i], " \t"))]
+		key, rest, err := parseTOMLKey(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		rest = strings.TrimLeft(rest, " \t")
+		if !strings.HasPrefix(rest, "=") {
+			return nil, fmt.Errorf("line %d: expected '=' after key", i+1)
+		}
+		keyText := strings.TrimSpace(trimmed[:len(trimmed)-len(rest)])
+		end, comment, err := scanTOMLValue(lines, i, len(indent)+len(trimmed)-len(rest)+1)
+		if err != nil {
+			return nil, err
+		}
+		doc.entries = append(doc.entries, tomlEntry{
+			path:    append(slices.Clone(table), key...),
+			table:   table,
+			keyText: keyText,
+			indent:  indent,
+			comment: comment,
+			start:   i,
+			end:     end,
+		})
+		i = end
+	}
+	return doc, nil
+}
+
+// parseTOMLKey parses a dotted key at the start of s, returning its parts and the rest of s.
+func parseTOMLKey(s string) ([]string, string, error) {
+	parts := make([]string, 0, 1)
+	for {
+		s = strings.TrimLeft(s, " \t")
+		if s == "" {
+			return nil, "", errors.New("expected key")
+		}
+		switch s[0] {
+		case '"':
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, "", errors.New("unterminated quoted key")
+			}
+			part, err := strconv.Unquote(s[:end+1])
+			if err != nil {
+				return nil, "", err
+			}
+			parts = append(parts, part)
+			s = s[end+1:]
+		case '\'':
+			end := strings.IndexByte(s[1:], '\'')
+			if end == -1 {
+				return nil, "", errors.New("unterminated quoted key")
+			}
+			parts = append(parts, s[1:end+1])
+			s = s[end+2:]
+		default:
+			end := strings.IndexFunc(s, func(r rune) bool { return !isTOMLBareKeyChar(r) })
+			if end == -1 {
+				end = len(s)
+			} else if end == 0 {
+				return nil, "", errors.New("invalid key")
+			}
+			parts = append(parts, s[:end])
+			s = s[end:]
+		}
+		s = strings.TrimLeft(s, " \t")
+		if !strings.HasPrefix(s, ".") {
+			return parts, s, nil
+		}
+		s = s[1:]
+	}
+}
+
+// scanTOMLValue finds the last line of the value starting at the given line and column, which may
+// span multiple lines for arrays, inline tables and multi-line strings. Trailing comments on the
+// last line are returned with their leading whitespace.
+func scanTOMLValue(lines []string, line, column int) (int, string, error) {
+	depth := 0
+	multiline := ""
+	for ; line < len(lines); line, column = line+1, 0 {
+		s := lines[line]
+		for i := column; i < len(s); i++ {
+			if multiline != "" {
+				if strings.HasPrefix(s[i:], multiline) {
+					i += len(multiline) - 1
+					multiline = ""
+				} else if s[i] == '\\' && multiline == `"""` {
+					i++
+				}
+				continue
+			}
+			switch {
+			case strings.HasPrefix(s[i:], `"""`), strings.HasPrefix(s[i:], "'''"):
+				multiline = s[i : i+3]
+				i += 2
+			case s[i] == '"':
+				for i++; i < len(s) && s[i] != '"'; i++ {
+					if s[i] == '\\' {
+						i++
+					}
+				}
+			case s[i] == '\'':
+				if end := strings.IndexByte(s[i+1:], '\''); end != -1 {
+					i += end + 1
+				}
+			case s[i] == '[', s[i] == '{':
+				depth++
+			case s[i] == ']', s[i] == '}':
+				depth--
+			case s[i] == '#':
+				if depth == 0 {
+					start := i
+					for start > column && (s[start-1] == ' ' || s[start-1] == '\t') {
+						start--
+					}
+					return line, s[start:], nil
+				}
+				i = len(s)
+			}
+		}
+		if depth <= 0 && multiline == "" {
+			return line, "", nil
+		}
+	}
+	return 0, "", errors.New("unterminated value")
+}
+
+func isTOMLBareKeyChar(r rune) bool {
+	return r == '_' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9')
+}
+
+func encodeTOMLKey(key string) string {
+	if key != "" && strings.IndexFunc(key, func(r rune) bool { return !isTOMLBareKeyChar(r) }) == -1 {
+		return key
+	}
+	return encodeTOMLString(key)
+}
+
+// encodeTOMLSection encodes a table as a section, with nested tables as subsections.
+func encodeTOMLSection(path []string, table map[string]interface{}) []string {
+	header := make([]string, len(path))
+	for i, part := range path {
+		header[i] = encodeTOMLKey(part)
+	}
+	lines := []string{"[" + strings.Join(header, ".") + "]"}
+	subtables := make([]string, 0)
+	for _, key := range sortedPatchKeys(table) {
+		if isTOMLTable(table[key]) {
+			subtables = append(subtables, key)
+			continue
+		}
+		encoded, err := encodeTOMLValue(table[key])
+		if err == nil {
+			lines = append(lines, encodeTOMLKey(key)+" = "+encoded)
+		}
+	}
+	for _, key := range subtables {
+		lines = append(lines, "")
+		lines = append(lines, encodeTOMLSection(append(slices.Clone(path), key), table[key].(map[string]interface{}))...)
+	}
+	return lines
+}
+
+func encodeTOMLValue(value interface{}) (string, error) {
+	switch value := value.(type) {
+	case string:
+		return encodeTOMLString(value), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+			return strconv.FormatInt(int64(value), 10), nil
+		}
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case []interface{}:
+		items := make([]string, len(value))
+		for i, item := range value {
+			encoded, err := encodeTOMLValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = encoded
+		}
+		return "[" + strings.Join(items, ", ") + "]", nil
+	case map[string]interface{}:
+		if len(value) == 0 {
+			return "{}", nil
+		}
+		items := make([]string, 0, len(value))
+		for _, key := range sortedPatchKeys(value) {
+			encoded, err := encodeTOMLValue(value[key])
+			if err != nil {
+				return "", err
+			}
+			items = append(items, encodeTOMLKey(key)+" = "+encoded)
+		}
+		return "{ " + strings.Join(items, ", ") + " }", nil
+	}
+	return "", fmt.Errorf("unsupported TOML value: %v", value)
+}
+
+func encodeTOMLString(s string) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			builder.WriteString(`\"`)
+		case '\\':
+			builder.WriteString(`\\`)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\t':
+			builder.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&builder, `\u%04X`, r)
+			} else {
+				builder.WriteRune(r)
+			}
+		}
+	}
+	builder.WriteByte('"')
+	return builder.String()
+}
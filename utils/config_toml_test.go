@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMergeTOML(t *testing.T) {
+	testConfigMerges(t, ConfigFormatTOML, []configMergeTest{
+		{
+			"keeps comments and order",
+			"# Header\nbind = \"0.0.0.0:25577\" # Address\n\n[servers]\n# Servers\nlobby = \"127.0.0.1:30066\"\ntry = [\"lobby\"]\n",
+			map[string]interface{}{"bind": "0.0.0.0:25565", "servers": map[string]interface{}{"hub": "127.0.0.1:30067"}},
+			"# Header\nbind = \"0.0.0.0:25565\" # Address\n\n[servers]\n# Servers\nlobby = \"127.0.0.1:30066\"\ntry = [\"lobby\"]\nhub = \"127.0.0.1:30067\"\n",
+			[]ConfigChange{
+				{Key: "bind", OldValue: "0.0.0.0:25577", NewValue: "0.0.0.0:25565"},
+				{Key: "servers.hub", NewValue: "127.0.0.1:30067"},
+			},
+		},
+		{
+			"nested tables",
+			"[a]\nx = 1\n\n[a.b]\ny = 2\n",
+			map[string]interface{}{"a": map[string]interface{}{"b": map[string]interface{}{"y": 3.0, "z": true}, "x": 1.0}},
+			"[a]\nx = 1\n\n[a.b]\ny = 3\nz = true\n",
+			[]ConfigChange{{Key: "a.b.y", OldValue: "2", NewValue: "3"}, {Key: "a.b.z", NewValue: "true"}},
+		},
+		{
+			"dotted keys",
+			"a.x = 1\n\"a\".'y' = 2\n",
+			map[string]interface{}{"a": map[string]interface{}{"x": 3.0, "y": nil, "z": "new"}},
+			"a.x = 3\na.z = \"new\"\n",
+			[]ConfigChange{{Key: "a.x", OldValue: "1", NewValue: "3"}, {Key: "a.y", OldValue: "2"}, {Key: "a.z", NewValue: "new"}},
+		},
+		{
+			"null deletes keys and tables",
+			"a = 1\nb = 2\n\n[c]\nd = 3\n\n[c.e]\nf = 4\n",
+			map[string]interface{}{"a": nil, "c": nil, "missing": nil},
+			"b = 2\n",
+			[]ConfigChange{{Key: "a", OldValue: "1"}, {Key: "c", OldValue: `{"d":3,"e":{"f":4}}`}},
+		},
+		{
+			"replaces multi-line values",
+			"list = [\n  \"a\", # First\n  \"b\",\n]\ntext = \"\"\"\nline\n\"\"\"\n",
+			map[string]interface{}{"list": []interface{}{"c"}, "text": "single"},
+			"list = [\"c\"]\ntext = \"single\"\n",
+			[]ConfigChange{{Key: "list", OldValue: `["a","b"]`, NewValue: `["c"]`}, {Key: "text", OldValue: "line\n", NewValue: "single"}},
+		},
+		{
+			"adds tables",
+			"a = 1\n",
+			map[string]interface{}{"forced-hosts": map[string]interface{}{"lobby.example.com": []interface{}{"lobby"}}},
+			"a = 1\n\n[forced-hosts]\n\"lobby.example.com\" = [\"lobby\"]\n",
+			[]ConfigChange{{Key: "forced-hosts", NewValue: `{"lobby.example.com":["lobby"]}`}},
+		},
+		{
+			"keeps crlf",
+			"# Header\r\na = 1\r\n\r\n[b]\r\nc = 2\r\n",
+			map[string]interface{}{"b": map[string]interface{}{"c": 3.0}},
+			"# Header\r\na = 1\r\n\r\n[b]\r\nc = 3\r\n",
+			[]ConfigChange{{Key: "b.c", OldValue: "2", NewValue: "3"}},
+		},
+		{
+			"empty file",
+			"",
+			map[string]interface{}{"a": "b"},
+			"a = \"b\"\n",
+			[]ConfigChange{{Key: "a", NewValue: "b"}},
+		},
+		{
+			"unchanged",
+			"a   =   1 # Spacing is kept\n",
+			map[string]interface{}{"a": 1.0, "b": nil},
+			"a   =   1 # Spacing is kept\n",
+			[]ConfigChange{},
+		},
+	})
+}
+
+func TestMergeTOMLArrayOfTables(t *testing.T) {
+	tests := []struct {
+		input string
+		patch map[string]interface{}
+		key   string
+	}{
+		{"[[a]]\nx = 1\n", map[string]interface{}{"a": map[string]interface{}{"x": 2.0}}, "a"},
+		{"[[a]]\nx = 1\n", map[string]interface{}{"a": nil}, "a"},
+		{"[b]\n[[b.a]]\nx = 1\n", map[string]interface{}{"b": map[string]interface{}{"a": map[string]interface{}{"x": 2.0}}}, "b.a"},
+	}
+	for _, test := range tests {
+		_, _, err := MergeConfigFile(ConfigFormatTOML, []byte(test.input), test.patch)
+		var patchErr UnsupportedTOMLPatchError
+		if !errors.As(err, &patchErr) || patchErr.Key != test.key {
+			t.Errorf("merging into %q = %v, want an UnsupportedTOMLPatchError for %s", test.input, err, test.key)
+		}
+	}
+}
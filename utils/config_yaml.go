@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"slices"
+
+	"github.com/goccy/go-yaml"
+	"github.com/goccy/go-yaml/ast"
+	"github.com/goccy/go-yaml/parser"
+)
+
+var errYAMLNotMapping = errors.New("top-level value is not a mapping")
+
+// mergeYAML merges a patch into a YAML document by editing its syntax tree, so that comments, key
+// order and formatting of untouched keys are kept as they are.
+func mergeYAML(data []byte, patch map[string]interface{}) ([]byte, []ConfigChange, error) {
+	crlf := bytes.Contains(data, []byte("\r\n"))
+	text := bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	file, err := parser.ParseBytes(text, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	changes := make([]ConfigChange, 0)
+	var merged []byte
+	if len(file.Docs) == 0 || isEmptyYAMLBody(file.Docs[0].Body) {
+		// Empty document or mapping, create it from scratch after any leading comments
+		patch := withoutNullValues(patch).(map[string]interface{})
+		for _, key := range sortedPatchKeys(patch) {
+			changes = append(changes, ConfigChange{Key: key, NewValue: FormatConfigValue(patch[key])})
+		}
+		if len(patch) == 0 {
+			return data, changes, nil
+		}
+		values, err := yaml.Marshal(yamlValue(patch))
+		if err != nil {
+			return nil, nil, err
+		}
+		merged = append(yamlLeadingComments(text), values...)
+	} else if merged, err = mergeYAMLDocument(file, patch, &changes); err != nil {
+		return nil, nil, err
+	} else if len(changes) == 0 {
+		return data, changes, nil
+	}
+	if crlf {
+		merged = bytes.ReplaceAll(merged, []byte("\n"), []byte("\r\n"))
+	}
+	return merged, changes, nil
+}
+
+// mergeYAMLDocument merges a patch into the top-level mapping of a parsed YAML file.
+func mergeYAMLDocument(file *ast.File, patch map[string]interface{}, changes *[]ConfigChange) ([]byte, error) {
+	mapping, ok := file.Docs[0].Body.(*ast.MappingNode)
+	if !ok || mapping.IsFlowStyle {
+		return nil, errYAMLNotMapping
+	} else if err := mergeYAMLMapping(mapping, patch, "", changes); err != nil {
+		return nil, err
+	}
+	merged := []byte(file.String())
+	if !bytes.HasSuffix(merged, []byte("\n")) {
+		merged = append(merged, '\n')
+	}
+	return merged, nil
+}
+
+func mergeYAMLMapping(
+	mapping *ast.MappingNode, patch map[string]interface{}, prefix string, changes *[]ConfigChange,
+) error {
+	column := mapping.GetToken().Position.Column
+	if len(mapping.Values) > 0 {
+		column = mapping.Values[0].Key.GetToken().Position.Column
+	}
+	for _, key := range sortedPatchKeys(patch) {
+		value := patch[key]
+		index := slices.IndexFunc(mapping.Values, func(entry *ast.MappingValueNode) bool {
+			return entry.Key.GetToken().Value == key
+		})
+		if index == -1 {
+			if value == nil {
+				continue
+			}
+			value = withoutNullValues(value)
+			entry, err := newYAMLEntry(key, value, column, false)
+			if err != nil {
+				return err
+			}
+			mapping.Values = append(mapping.Values, entry)
+			*changes = append(*changes, ConfigChange{Key: prefix + key, NewValue: FormatConfigValue(value)})
+			continue
+		}
+
+		entry := mapping.Values[index]
+		if subPatch, ok := value.(map[string]interface{}); ok {
+			if subMapping, ok := entry.Value.(*ast.MappingNode); ok && !subMapping.IsFlowStyle {
+				if err := mergeYAMLMapping(subMapping, subPatch, prefix+key+".", changes); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		var oldValue interface{}
+		if err := yaml.NodeToValue(entry.Value, &oldValue); err != nil {
+			return err
+		}
+		if value == nil {
+			mapping.Values = slices.Delete(mapping.Values, index, index+1)
+			*changes = append(*changes, ConfigChange{Key: prefix + key, OldValue: FormatConfigValue(oldValue)})
+			continue
+		}
+		value = withoutNullValues(value)
+		if configValuesEqual(oldValue, value) {
+			continue
+		}
+		newEntry, err := newYAMLEntry(key, value, column, isYAMLSequenceIndented(entry))
+		if err != nil {
+			return err
+		}
+		// Keep the comments of the replaced value
+		if comment := entry.GetComment(); comment != nil {
+			if err := newEntry.SetComment(comment); err != nil {
+				return err
+			}
+		}
+		if _, ok := newEntry.Value.(ast.ScalarNode); ok {
+			if comment := entry.Value.GetComment(); comment != nil {
+				if err := newEntry.Value.SetComment(comment); err != nil {
+					return err
+				}
+			}
+		}
+		// Keep the original key, as it may be quoted
+		newEntry.Key = entry.Key
+		mapping.Values[index] = newEntry
+		*changes = append(*changes, ConfigChange{
+			Key:      prefix + key,
+			OldValue: FormatConfigValue(oldValue),
+			NewValue: FormatConfigValue(value),
+		})
+	}
+	return nil
+}
+
+// isEmptyYAMLBody checks if a document has no values, only comments or an empty mapping like {}.
+func isEmptyYAMLBody(body ast.Node) bool {
+	switch body := body.(type) {
+	case nil, *ast.CommentGroupNode:
+		return true
+	case *ast.MappingNode:
+		return len(body.Values) == 0
+	}
+	return false
+}
+
+// yamlLeadingComments returns the comments and blank lines at the start of a document.
+func yamlLeadingComments(data []byte) []byte {
+	comments := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		line, rest, _ := bytes.Cut(data, []byte("\n"))
+		if trimmed := bytes.TrimSpace(line); len(trimmed) != 0 && trimmed[0] != '#' {
+			break
+		}
+		comments = append(append(comments, line...), '\n')
+		data = rest
+	}
+	return comments
+}
+
+// newYAMLEntry creates a mapping entry for a key and value, with the key at the given column.
+func newYAMLEntry(key string, value interface{}, column int, indentSequence bool) (*ast.MappingValueNode, error) {
+	value = map[string]interface{}{key: yamlValue(value)}
+	node, err := yaml.ValueToNode(value, yaml.IndentSequence(indentSequence))
+	if err != nil {
+		return nil, err
+	}
+	mapping, ok := node.(*ast.MappingNode)
+	if !ok || len(mapping.Values) != 1 {
+		return nil, errYAMLNotMapping
+	}
+	entry := mapping.Values[0]
+	entry.AddColumn(column - entry.Key.GetToken().Position.Column)
+	return entry, nil
+}
+
+// isYAMLSequenceIndented checks if the value of an entry is a sequence indented under its key.
+func isYAMLSequenceIndented(entry *ast.MappingValueNode) bool {
+	sequence, ok := entry.Value.(*ast.SequenceNode)
+	return ok && !sequence.IsFlowStyle &&
+		sequence.GetToken().Position.Column > entry.Key.GetToken().Position.Column
+}
+
+// yamlValue converts whole numbers in a patch value to integers, so they aren't written as floats.
+func yamlValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case float64:
+		if value == math.Trunc(value) && math.Abs(value) < 1<<53 {
+			return int64(value)
+		}
+	case []interface{}:
+		result := make([]interface{}, len(value))
+		for i, item := range value {
+			result[i] = yamlValue(item)
+		}
+		return result
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			result[key] = yamlValue(item)
+		}
+		return result
+	}
+	return value
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMergeYAML(t *testing.T) {
+	testConfigMerges(t, ConfigFormatYAML, []configMergeTest{
+		{
+			"keeps comments and order",
+			"# Header\nsettings:\n  # Debug mode\n  debug: false # Off\n  port: 25565\nname: hub\n",
+			map[string]interface{}{"settings": map[string]interface{}{"debug": true}},
+			"# Header\nsettings:\n  # Debug mode\n  debug: true # Off\n  port: 25565\nname: hub\n",
+			[]ConfigChange{{Key: "settings.debug", OldValue: "false", NewValue: "true"}},
+		},
+		{
+			"adds nested keys",
+			"settings:\n    port: 25565\n",
+			map[string]interface{}{"settings": map[string]interface{}{"motd": "Hi", "limits": map[string]interface{}{"max": 10.0}}},
+			"settings:\n    port: 25565\n    limits:\n      max: 10\n    motd: Hi\n",
+			[]ConfigChange{{Key: "settings.limits", NewValue: `{"max":10}`}, {Key: "settings.motd", NewValue: "Hi"}},
+		},
+		{
+			"null deletes keys",
+			"a: 1\nb:\n  c: 2\n  d: 3\n",
+			map[string]interface{}{"a": nil, "b": map[string]interface{}{"c": nil}, "missing": nil},
+			"b:\n  d: 3\n",
+			[]ConfigChange{{Key: "a", OldValue: "1"}, {Key: "b.c", OldValue: "2"}},
+		},
+		{
+			"replaces arrays and scalars with mappings",
+			"list:\n  - a\n  - b\nvalue: 1\n",
+			map[string]interface{}{"list": []interface{}{"c"}, "value": map[string]interface{}{"x": "text", "z": nil}},
+			"list:\n  - c\nvalue:\n  x: text\n",
+			[]ConfigChange{{Key: "list", OldValue: `["a","b"]`, NewValue: `["c"]`}, {Key: "value", OldValue: "1", NewValue: `{"x":"text"}`}},
+		},
+		{
+			"keeps crlf",
+			"# Header\r\na: 1\r\nb: 2\r\n",
+			map[string]interface{}{"a": 3.0},
+			"# Header\r\na: 3\r\nb: 2\r\n",
+			[]ConfigChange{{Key: "a", OldValue: "1", NewValue: "3"}},
+		},
+		{
+			"empty document",
+			"",
+			map[string]interface{}{"a": map[string]interface{}{"b": true, "c": nil}},
+			"a:\n  b: true\n",
+			[]ConfigChange{{Key: "a", NewValue: `{"b":true}`}},
+		},
+		{
+			"comment-only document",
+			"# Generated\n\n",
+			map[string]interface{}{"a": 1.0},
+			"# Generated\n\na: 1\n",
+			[]ConfigChange{{Key: "a", NewValue: "1"}},
+		},
+		{
+			"empty mapping",
+			"# Generated\n{}\n",
+			map[string]interface{}{"a": 1.0},
+			"# Generated\na: 1\n",
+			[]ConfigChange{{Key: "a", NewValue: "1"}},
+		},
+		{
+			"empty nested mapping",
+			"a: {}\nb: 1\n",
+			map[string]interface{}{"a": map[string]interface{}{"c": "d"}},
+			"a:\n  c: d\nb: 1\n",
+			[]ConfigChange{{Key: "a", OldValue: "{}", NewValue: `{"c":"d"}`}},
+		},
+		{
+			"unchanged",
+			"a:   1 # Spacing is kept\n",
+			map[string]interface{}{"a": 1.0, "b": nil},
+			"a:   1 # Spacing is kept\n",
+			[]ConfigChange{},
+		},
+	})
+}
+
+func TestMergeYAMLErrors(t *testing.T) {
+	for _, input := range []string{"- a\n- b\n", "{a: 1}\n", "a: [\n"} {
+		if _, _, err := MergeConfigFile(ConfigFormatYAML, []byte(input), map[string]interface{}{"a": 2.0}); err == nil {
+			t.Errorf("merging into %q succeeded", input)
+		} else if !errors.As(err, new(InvalidConfigFileError)) {
+			t.Errorf("merging into %q = %v, want an InvalidConfigFileError", input, err)
+		}
+	}
+}
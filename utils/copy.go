@@ -1,26 +1,98 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
+type ChecksumMismatchError struct{ Path, Expected, Actual string }
+
+func (e ChecksumMismatchError) Error() string {
+	return "checksum mismatch while writing " + e.Path + ": expected " + e.Expected + ", got " + e.Actual
+}
+
+// CopyFile atomically replaces dst with a copy of src. See CopyFileVerified.
 func CopyFile(src, dst string) error {
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
-	}
-	defer destFile.Close()
+	return CopyFileVerified(src, dst, "")
+}
 
+// CopyFileVerified atomically replaces dst with a copy of src. If checksum is not empty, the copy is
+// only put in place if the SHA-256 hash of the copied data matches it.
+func CopyFileVerified(src, dst, checksum string) error {
 	srcFile, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer srcFile.Close()
 
-	_, err = io.Copy(destFile, srcFile)
+	return writeFileAtomic(dst, 0644, func(w io.Writer) error {
+		hash := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(w, hash), srcFile); err != nil {
+			return err
+		}
+		actual := hex.EncodeToString(hash.Sum(nil))
+		if checksum != "" && !strings.EqualFold(actual, checksum) {
+			return ChecksumMismatchError{Path: dst, Expected: checksum, Actual: actual}
+		}
+		return nil
+	})
+}
+
+// WriteFileAtomic atomically replaces the file at path with data. If the file already exists, its
+// permissions and ownership are preserved, else it is created with the given permissions.
+func WriteFileAtomic(path string, data []byte, perm fs.FileMode) error {
+	return writeFileAtomic(path, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// writeFileAtomic writes to a temporary file next to path, syncs it to disk, then renames it over
+// path, so that a crash or full disk never leaves a partially written file behind.
+func writeFileAtomic(path string, perm fs.FileMode, write func(w io.Writer) error) (err error) {
+	// Replace the target of symlinks, not the symlinks themselves
+	if resolved, err := filepath.EvalSymlinks(path); err == nil {
+		path = resolved
+	}
+	stat, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	} else if err == nil {
+		perm = stat.Mode().Perm()
+	}
+
+	dir := filepath.Dir(path)
+	tmpFile, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-")
 	if err != nil {
 		return err
 	}
-	return nil
+	defer func() {
+		if err != nil {
+			tmpFile.Close()
+			os.Remove(tmpFile.Name())
+		}
+	}()
+
+	if err = write(tmpFile); err != nil {
+		return err
+	} else if err = tmpFile.Sync(); err != nil {
+		return err
+	} else if err = tmpFile.Chmod(perm); err != nil {
+		return err
+	} else if stat != nil {
+		if err = chownLike(tmpFile, stat); err != nil {
+			return err
+		}
+	}
+	if err = tmpFile.Close(); err != nil {
+		return err
+	} else if err = os.Rename(tmpFile.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
 }
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package utils
+
+import (
+	"io/fs"
+	"os"
+)
+
+func chownLike(file *os.File, stat fs.FileInfo) error { return nil }
+
+func syncDir(dir string) error { return nil }
+
+func fileInode(stat fs.FileInfo) uint64 { return 0 }
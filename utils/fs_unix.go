@@ -0,0 +1,42 @@
+//go:build unix
+
+package utils
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"syscall"
+)
+
+// chownLike gives file the same owner and group as the file described by stat. If the current user
+// isn't allowed to do so, the file is left owned by the current user.
+func chownLike(file *os.File, stat fs.FileInfo) error {
+	sys, ok := stat.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	err := file.Chown(int(sys.Uid), int(sys.Gid))
+	if errors.Is(err, fs.ErrPermission) {
+		return nil
+	}
+	return err
+}
+
+// syncDir syncs a directory to disk, persisting renames of files inside it.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// fileInode returns the inode number of the file described by stat.
+func fileInode(stat fs.FileInfo) uint64 {
+	if sys, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return uint64(sys.Ino)
+	}
+	return 0
+}
@@ -15,14 +15,14 @@ func HashFilePath(path string) (string, error) {
 	}
 	defer file.Close()
 
+	return HashReader(file)
+}
+
+// HashReader hashes everything read from r, without holding it in memory.
+func HashReader(r io.Reader) (string, error) {
 	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
+	if _, err := io.Copy(hash, r); err != nil {
 		return "", err
 	}
 	return strings.ToLower(hex.EncodeToString(hash.Sum(nil))), nil
 }
-
-func HashData(data []byte) string {
-	hash := sha256.Sum256(data)
-	return strings.ToLower(hex.EncodeToString(hash[:]))
-}
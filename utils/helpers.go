@@ -9,6 +9,14 @@ func PickNonEmptyString(a, b string) string {
 	return b
 }
 
+// ShortHash truncates a checksum for display purposes.
+func ShortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
+
 func WaitGroupDoneAsChannel(wg *sync.WaitGroup) <-chan struct{} {
 	ch := make(chan struct{})
 	go func() {
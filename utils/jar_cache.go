@@ -0,0 +1,190 @@
+package utils
+
+import (
+	"cmp"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const jarCacheFormatVersion = 5 // Bumped when JarInfo changes, to inspect JARs again
+
+// JarInfo is the result of inspecting a JAR file.
+type JarInfo struct {
+	Checksum string           `json:"checksum"`
+	Type     string           `json:"type"`
+	Plugins  []PluginMetadata `json:"plugins,omitempty"` // Metadata of each platform, only set for plugins
+	Version  string           `json:"version,omitempty"` // Only set for software, if it could be determined
+}
+
+// MetadataFor returns the metadata of a plugin JAR for the platform loaded by the given software,
+// falling back to the metadata of its other platforms. It returns false if the JAR isn't a plugin.
+func (i JarInfo) MetadataFor(software string) (PluginMetadata, bool) {
+	return SelectPluginMetadata(i.Plugins, software)
+}
+
+type JarMetadataError struct {
+	Path string
+	Err  error
+}
+
+func (e JarMetadataError) Error() string {
+	return "failed to load plugin metadata from " + e.Path + ": " + e.Err.Error()
+}
+
+func (e JarMetadataError) Unwrap() error { return e.Err }
+
+// JarCache is an on-disk cache of JAR inspection results, keyed by the path, size, modification time
+// and inode of each JAR, so unchanged JARs don't need to be read and hashed again. A nil JarCache
+// is valid, and inspects every JAR without caching.
+type JarCache struct {
+	path    string
+	mutex   sync.Mutex
+	entries map[string]jarCacheEntry
+	dirty   bool
+}
+
+type jarCacheEntry struct {
+	Size    int64   `json:"size"`
+	ModTime int64   `json:"mod_time"`
+	Inode   uint64  `json:"inode"`
+	Info    JarInfo `json:"info"`
+}
+
+type jarCacheFile struct {
+	FormatVersion int                      `json:"format_version"`
+	Entries       map[string]jarCacheEntry `json:"entries"`
+}
+
+// LoadJarCache loads the JAR cache at the given path. A missing, corrupt or outdated cache file is
+// treated as an empty cache.
+func LoadJarCache(path string) (*JarCache, error) {
+	cache := &JarCache{path: path, entries: make(map[string]jarCacheEntry)}
+	data, err := os.ReadFile(path)
+	if err != nil && os.IsNotExist(err) {
+		return cache, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var file jarCacheFile
+	err = json.Unmarshal(data, &file)
+	if err == nil && file.FormatVersion == jarCacheFormatVersion && file.Entries != nil {
+		cache.entries = file.Entries
+	}
+	return cache, nil
+}
+
+// Save writes the cache back to disk if it changed, dropping entries of JARs which no longer exist.
+func (c *JarCache) Save() error {
+	if c == nil {
+		return nil
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for path := range c.entries {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(c.entries, path)
+			c.dirty = true
+		}
+	}
+	if !c.dirty {
+		return nil
+	}
+	data, err := json.Marshal(jarCacheFile{FormatVersion: jarCacheFormatVersion, Entries: c.entries})
+	if err != nil {
+		return err
+	} else if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	} else if err := WriteFileAtomic(c.path, data, 0644); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// Inspect determines the type and checksum of a JAR, and parses its metadata if it is a plugin.
+// It returns ErrUnknownJarType if the JAR type isn't recognised, and a JarMetadataError if the
+// plugin metadata is invalid.
+func (c *JarCache) Inspect(path string) (JarInfo, error) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		return JarInfo{}, err
+	}
+	entry := jarCacheEntry{Size: stat.Size(), ModTime: stat.ModTime().UnixNano(), Inode: fileInode(stat)}
+	if c != nil {
+		c.mutex.Lock()
+		cached, ok := c.entries[path]
+		c.mutex.Unlock()
+		if ok && cached.Size == entry.Size && cached.ModTime == entry.ModTime && cached.Inode == entry.Inode {
+			return cached.Info, nil
+		}
+	}
+
+	entry.Info, err = inspectJar(path)
+	if err != nil {
+		return JarInfo{}, err
+	}
+	if c != nil {
+		c.mutex.Lock()
+		c.entries[path] = entry
+		c.dirty = true
+		c.mutex.Unlock()
+	}
+	return entry.Info, nil
+}
+
+// Hash returns the checksum of a file, using the cached inspection result if it is an unchanged JAR.
+func (c *JarCache) Hash(path string) (string, error) {
+	info, err := c.Inspect(path)
+	if err != nil && os.IsNotExist(err) {
+		return "", err
+	} else if err != nil {
+		return HashFilePath(path) // Not a recognised JAR, hash it without caching
+	}
+	return info.Checksum, nil
+}
+
+// inspectJar inspects a JAR with a single streaming pass for hashing, so that memory use doesn't
+// depend on the size of the JAR.
+func inspectJar(path string) (JarInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return JarInfo{}, err
+	}
+	defer file.Close()
+	stat, err := file.Stat()
+	if err != nil {
+		return JarInfo{}, err
+	}
+
+	jarType, files, err := DetermineJarType(file, stat.Size())
+	if err != nil {
+		return JarInfo{}, err
+	}
+	checksum, err := HashReader(io.NewSectionReader(file, 0, stat.Size()))
+	if err != nil {
+		return JarInfo{}, err
+	}
+	info := JarInfo{Checksum: checksum, Type: jarType}
+	if jarType != "plugin" {
+		info.Version = ParseSoftwareVersion(jarType, files)
+		return info, nil
+	}
+	// Skip invalid metadata files, unless none of the plugin's platforms have valid metadata
+	var metadataErr error
+	for _, metadataFile := range pluginMetadataFiles {
+		if data, ok := files[metadataFile.Name]; !ok {
+			continue
+		} else if metadata, err := ParsePluginMetadata(filepath.Base(path), metadataFile.Name, data); err != nil {
+			metadataErr = cmp.Or(metadataErr, err)
+		} else {
+			info.Plugins = append(info.Plugins, metadata)
+		}
+	}
+	if len(info.Plugins) == 0 {
+		return JarInfo{}, JarMetadataError{Path: path, Err: metadataErr}
+	}
+	return info, nil
+}
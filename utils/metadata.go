@@ -2,54 +2,317 @@ package utils
 
 import (
 	"archive/zip"
-	"bytes"
+	"encoding/json"
 	"errors"
 	"io"
+	"maps"
 	"slices"
+	"strings"
 
 	"github.com/goccy/go-yaml"
 )
 
+// Plugin platforms, each with their own metadata file.
+const (
+	PlatformBukkit   = "bukkit"   // plugin.yml, loaded by Paper
+	PlatformPaper    = "paper"    // paper-plugin.yml, loaded by Paper
+	PlatformBungee   = "bungee"   // bungee.yml, loaded by BungeeCord
+	PlatformVelocity = "velocity" // velocity-plugin.json, loaded by Velocity
+)
+
+type pluginMetadataFile struct{ Name, Platform string }
+
+// pluginMetadataFiles are the metadata files of each platform, in order of preference when a plugin
+// is deployed to software which doesn't load any of its platforms.
+var pluginMetadataFiles = []pluginMetadataFile{
+	{Name: "plugin.yml", Platform: PlatformBukkit},
+	{Name: "paper-plugin.yml", Platform: PlatformPaper},
+	{Name: "bungee.yml", Platform: PlatformBungee},
+	{Name: "velocity-plugin.json", Platform: PlatformVelocity},
+}
+
+// SoftwarePlatforms returns the plugin platforms loaded by server software, in order of preference
+// when a plugin supports several of them, as Paper prefers paper-plugin.yml over plugin.yml.
+func SoftwarePlatforms(software string) []string {
+	switch software {
+	case "paper":
+		return []string{PlatformPaper, PlatformBukkit}
+	case "velocity":
+		return []string{PlatformVelocity}
+	}
+	return nil // Vanilla servers don't load plugins
+}
+
+// PluginMetadata describes a plugin on one of the platforms it supports, read from the metadata
+// file of that platform.
 type PluginMetadata struct {
-	Name    string `yaml:"name"`
-	Version string `yaml:"version"`
+	Platform   string   `json:"platform"`
+	ID         string   `json:"id"`   // Plugin name on Bukkit, Paper and BungeeCord, plugin ID on Velocity
+	Name       string   `json:"name"` // Display name, which configs refer to plugins by
+	Version    string   `json:"version"`
+	Authors    []string `json:"authors,omitempty"`
+	Main       string   `json:"main,omitempty"`
+	APIVersion string   `json:"api_version,omitempty"` // Only set on Bukkit and Paper
+	Depend     []string `json:"depend,omitempty"`      // Plugins required to load this plugin
+	SoftDepend []string `json:"softdepend,omitempty"`  // Plugins loaded before this plugin if present
+	LoadBefore []string `json:"loadbefore,omitempty"`  // Plugins loaded after this plugin, only on Bukkit and Paper
 }
 
-type InvalidPluginMetadataError struct{ FileName string }
+// Provides checks if the plugin satisfies a dependency on the given plugin name or ID.
+func (m PluginMetadata) Provides(dependency string) bool {
+	return m.ID == dependency || m.Name == dependency
+}
+
+// SelectPluginMetadata returns the metadata of a plugin for the platform loaded by the given
+// software, falling back to the metadata of its other platforms in order of preference.
+func SelectPluginMetadata(metadata []PluginMetadata, software string) (PluginMetadata, bool) {
+	for _, platform := range SoftwarePlatforms(software) {
+		for _, platformMetadata := range metadata {
+			if platformMetadata.Platform == platform {
+				return platformMetadata, true
+			}
+		}
+	}
+	if len(metadata) == 0 {
+		return PluginMetadata{}, false
+	}
+	return metadata[0], true
+}
+
+type bukkitMetadataFile struct {
+	Name       yamlScalar `yaml:"name"`
+	Version    yamlScalar `yaml:"version"`
+	Main       yamlScalar `yaml:"main"`
+	APIVersion yamlScalar `yaml:"api-version"`
+	Author     yamlScalar `yaml:"author"`
+	Authors    yamlList   `yaml:"authors"`
+	Depend     yamlList   `yaml:"depend"`
+	SoftDepend yamlList   `yaml:"softdepend"`
+	LoadBefore yamlList   `yaml:"loadbefore"`
+}
+
+type paperMetadataFile struct {
+	Name         yamlScalar `yaml:"name"`
+	Version      yamlScalar `yaml:"version"`
+	Main         yamlScalar `yaml:"main"`
+	APIVersion   yamlScalar `yaml:"api-version"`
+	Author       yamlScalar `yaml:"author"`
+	Authors      yamlList   `yaml:"authors"`
+	Dependencies struct {
+		Server map[string]struct {
+			Load     string `yaml:"load"`     // BEFORE if the dependency loads first, AFTER if it loads last
+			Required *bool  `yaml:"required"` // Defaults to true
+		} `yaml:"server"`
+	} `yaml:"dependencies"`
+}
+
+type bungeeMetadataFile struct {
+	Name        yamlScalar `yaml:"name"`
+	Version     yamlScalar `yaml:"version"`
+	Main        yamlScalar `yaml:"main"`
+	Author      yamlScalar `yaml:"author"`
+	Depends     yamlList   `yaml:"depends"`
+	SoftDepends yamlList   `yaml:"softDepends"`
+}
+
+type velocityMetadataFile struct {
+	ID           string   `json:"id"`
+	Name         string   `json:"name"`
+	Version      string   `json:"version"`
+	Main         string   `json:"main"`
+	Authors      []string `json:"authors"`
+	Dependencies []struct {
+		ID       string `json:"id"`
+		Optional bool   `json:"optional"`
+	} `json:"dependencies"`
+}
+
+// yamlScalar is a string which keeps numbers as written, so that e.g. api-version: 1.20 isn't read as 1.2.
+type yamlScalar string
+
+func (s *yamlScalar) UnmarshalYAML(data []byte) error {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch value := value.(type) {
+	case nil:
+		*s = ""
+	case string:
+		*s = yamlScalar(value)
+	case []interface{}, map[string]interface{}:
+		return errNotYamlScalar
+	default:
+		literal, _, _ := strings.Cut(string(data), "#")
+		*s = yamlScalar(strings.TrimSpace(literal))
+	}
+	return nil
+}
+
+var errNotYamlScalar = errors.New("expected a string or number")
+
+// yamlList is a list of strings which may also be written as a single string.
+type yamlList []string
+
+func (l *yamlList) UnmarshalYAML(data []byte) error {
+	var value yamlScalar
+	if err := yaml.Unmarshal(data, &value); err == nil {
+		*l = yamlList{string(value)}
+		return nil
+	}
+	var list []yamlScalar
+	if err := yaml.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = make(yamlList, 0, len(list))
+	for _, item := range list {
+		*l = append(*l, string(item))
+	}
+	return nil
+}
+
+type InvalidPluginMetadataError struct{ FileName, MetadataFile, Field string }
 
 func (e InvalidPluginMetadataError) Error() string {
-	return "invalid metadata for plugin " + e.FileName + ": missing name or version"
+	return "invalid metadata for plugin " + e.FileName + ": missing " + e.Field + " in " + e.MetadataFile
 }
 
-// ParsePluginMetadata retrieves the plugin name and version from its metadata file.
-func ParsePluginMetadata(filename string, metadataFile []byte) (PluginMetadata, error) {
-	var pluginMetadata PluginMetadata
-	err := yaml.Unmarshal(metadataFile, &pluginMetadata)
-	if err != nil {
-		return PluginMetadata{}, err
-	} else if pluginMetadata.Name == "" || pluginMetadata.Version == "" {
-		return PluginMetadata{}, InvalidPluginMetadataError{FileName: filename}
+// ParsePluginMetadata retrieves the name, version, authors, entrypoint and dependencies of a plugin
+// from one of its metadata files, which are plugin.yml, paper-plugin.yml, bungee.yml or
+// velocity-plugin.json.
+func ParsePluginMetadata(filename, metadataFileName string, metadataFile []byte) (PluginMetadata, error) {
+	var metadata PluginMetadata
+	switch metadataFileName {
+	case "plugin.yml":
+		var file bukkitMetadataFile
+		if err := yaml.Unmarshal(metadataFile, &file); err != nil {
+			return PluginMetadata{}, err
+		}
+		metadata = PluginMetadata{
+			Platform:   PlatformBukkit,
+			ID:         string(file.Name),
+			Name:       string(file.Name),
+			Version:    string(file.Version),
+			Authors:    file.Authors,
+			Main:       string(file.Main),
+			APIVersion: string(file.APIVersion),
+			Depend:     file.Depend,
+			SoftDepend: file.SoftDepend,
+			LoadBefore: file.LoadBefore,
+		}
+		if file.Author != "" {
+			metadata.Authors = append([]string{string(file.Author)}, metadata.Authors...)
+		}
+	case "paper-plugin.yml":
+		var file paperMetadataFile
+		if err := yaml.Unmarshal(metadataFile, &file); err != nil {
+			return PluginMetadata{}, err
+		}
+		metadata = PluginMetadata{
+			Platform:   PlatformPaper,
+			ID:         string(file.Name),
+			Name:       string(file.Name),
+			Version:    string(file.Version),
+			Authors:    file.Authors,
+			Main:       string(file.Main),
+			APIVersion: string(file.APIVersion),
+		}
+		if file.Author != "" {
+			metadata.Authors = append([]string{string(file.Author)}, metadata.Authors...)
+		}
+		for _, name := range slices.Sorted(maps.Keys(file.Dependencies.Server)) {
+			dependency := file.Dependencies.Server[name]
+			if dependency.Required == nil || *dependency.Required {
+				metadata.Depend = append(metadata.Depend, name)
+			} else {
+				metadata.SoftDepend = append(metadata.SoftDepend, name)
+			}
+			if strings.EqualFold(dependency.Load, "AFTER") {
+				metadata.LoadBefore = append(metadata.LoadBefore, name)
+			}
+		}
+	case "bungee.yml":
+		var file bungeeMetadataFile
+		if err := yaml.Unmarshal(metadataFile, &file); err != nil {
+			return PluginMetadata{}, err
+		}
+		metadata = PluginMetadata{
+			Platform:   PlatformBungee,
+			ID:         string(file.Name),
+			Name:       string(file.Name),
+			Version:    string(file.Version),
+			Main:       string(file.Main),
+			Depend:     file.Depends,
+			SoftDepend: file.SoftDepends,
+		}
+		if file.Author != "" {
+			metadata.Authors = []string{string(file.Author)}
+		}
+	case "velocity-plugin.json":
+		var file velocityMetadataFile
+		if err := json.Unmarshal(metadataFile, &file); err != nil {
+			return PluginMetadata{}, err
+		}
+		metadata = PluginMetadata{
+			Platform: PlatformVelocity,
+			ID:       file.ID,
+			Name:     PickNonEmptyString(file.Name, file.ID),
+			Version:  file.Version,
+			Authors:  file.Authors,
+			Main:     file.Main,
+		}
+		for _, dependency := range file.Dependencies {
+			if dependency.Optional {
+				metadata.SoftDepend = append(metadata.SoftDepend, dependency.ID)
+			} else {
+				metadata.Depend = append(metadata.Depend, dependency.ID)
+			}
+		}
+	default:
+		return PluginMetadata{}, ErrUnknownJarType
 	}
-	return pluginMetadata, nil
+
+	if metadata.ID == "" {
+		field := "name"
+		if metadata.Platform == PlatformVelocity {
+			field = "id"
+		}
+		return PluginMetadata{}, InvalidPluginMetadataError{
+			FileName: filename, MetadataFile: metadataFileName, Field: field,
+		}
+	} else if metadata.Version == "" {
+		return PluginMetadata{}, InvalidPluginMetadataError{
+			FileName: filename, MetadataFile: metadataFileName, Field: "version",
+		}
+	}
+	return metadata, nil
 }
 
 var ErrUnknownJarType = errors.New("unknown JAR type")
 
-// DetermineJarType checks what type of software the given JAR is.
+// maxMetadataFileSize limits how much of a plugin metadata or software version file is read into memory.
+const maxMetadataFileSize = 1024 * 1024
+
+// DetermineJarType checks what type of software the given JAR is, only reading the ZIP central
+// directory and the files describing the JAR from it, rather than the whole JAR. These files are
+// returned keyed by name: the metadata files of each platform for plugins, and the version file for
+// software, which is version.json for vanilla and Paper, and META-INF/MANIFEST.MF for Velocity.
 // Supported types are:
 // - "vanilla"
 // - "paper"
 // - "velocity"
 // - "plugin"
-func DetermineJarType(jar []byte) (string, []byte, error) {
-	r, err := zip.NewReader(bytes.NewReader(jar), int64(len(jar)))
+func DetermineJarType(jar io.ReaderAt, size int64) (string, map[string][]byte, error) {
+	r, err := zip.NewReader(jar, size)
 	if err != nil {
 		return "", nil, err
 	}
 	isVelocity := false
 	isPaper := false
 	isVanilla := false
-	isPlugin := slices.IndexFunc(r.File, func(file *zip.File) bool {
+	metadataFiles := make([]*zip.File, 0)
+	var versionFile, manifestFile *zip.File
+	for _, file := range r.File {
 		switch file.Name {
 		case "com/velocitypowered/proxy/Velocity.class":
 			isVelocity = true
@@ -59,28 +322,78 @@ func DetermineJarType(jar []byte) (string, []byte, error) {
 			isVanilla = true
 		case "net/minecraft/bundler/Main.class":
 			isVanilla = true
+		case "version.json":
+			versionFile = file
+		case "META-INF/MANIFEST.MF":
+			manifestFile = file
 		}
-		return file.Name == "plugin.yml" ||
-			file.Name == "bungee.yml" ||
-			file.Name == "velocity-plugin.json"
-	})
-	if isPlugin != -1 {
-		metadataFile, err := r.File[isPlugin].Open()
-		if err != nil {
-			return "", nil, err
+		if slices.ContainsFunc(pluginMetadataFiles, func(metadataFile pluginMetadataFile) bool {
+			return metadataFile.Name == file.Name
+		}) {
+			metadataFiles = append(metadataFiles, file)
 		}
-		defer metadataFile.Close()
-		metadata, err := io.ReadAll(metadataFile)
+	}
+	switch {
+	case len(metadataFiles) > 0:
+		files, err := readZipFiles(metadataFiles...)
+		return "plugin", files, err
+	case isVelocity:
+		files, err := readZipFiles(manifestFile)
+		return "velocity", files, err
+	case isPaper:
+		files, err := readZipFiles(versionFile)
+		return "paper", files, err
+	case isVanilla:
+		files, err := readZipFiles(versionFile)
+		return "vanilla", files, err
+	}
+	return "", nil, ErrUnknownJarType
+}
+
+// readZipFiles reads files from a ZIP, up to maxMetadataFileSize bytes each, skipping nil files.
+func readZipFiles(files ...*zip.File) (map[string][]byte, error) {
+	contents := make(map[string][]byte)
+	for _, file := range files {
+		if file == nil {
+			continue
+		}
+		data, err := readZipFile(file)
 		if err != nil {
-			return "", nil, err
+			return nil, err
 		}
-		return "plugin", metadata, nil
-	} else if isVelocity {
-		return "velocity", nil, nil
-	} else if isPaper {
-		return "paper", nil, nil
-	} else if isVanilla {
-		return "vanilla", nil, nil
+		contents[file.Name] = data
 	}
-	return "", nil, ErrUnknownJarType
+	return contents, nil
+}
+
+// readZipFile reads a file from a ZIP, up to maxMetadataFileSize bytes.
+func readZipFile(file *zip.File) ([]byte, error) {
+	reader, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return io.ReadAll(io.LimitReader(reader, maxMetadataFileSize))
+}
+
+// ParseSoftwareVersion retrieves the version of server software from its version file, which is the
+// Minecraft version for vanilla and Paper, and the Velocity version for Velocity. It returns an empty
+// string if the version can't be determined.
+func ParseSoftwareVersion(jarType string, files map[string][]byte) string {
+	if jarType == "velocity" {
+		for _, line := range strings.Split(string(files["META-INF/MANIFEST.MF"]), "\n") {
+			if version, ok := strings.CutPrefix(line, "Implementation-Version:"); ok {
+				return strings.TrimSpace(version)
+			}
+		}
+		return ""
+	}
+	var version struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(files["version.json"], &version); err != nil {
+		return ""
+	}
+	return PickNonEmptyString(version.ID, version.Name)
 }
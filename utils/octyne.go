@@ -4,17 +4,53 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
-var httpc = http.Client{
-	Transport: &http.Transport{
-		DialContext: func(_ context.Context, _, _ string) (net.Conn, error) {
-			return net.Dial("unix", "/tmp/octyne.sock.42069")
-		},
-	},
+const DefaultOctyneURL = "unix:///tmp/octyne.sock.42069"
+
+// OctyneConfig describes how to connect to an Octyne instance. Connections over a Unix socket need
+// no authentication, while HTTP(S) connections use either a token or a username and password.
+type OctyneConfig struct {
+	URL      string `json:"url"`    // "unix:///path/to/socket" or "http(s)://host:port"
+	Server   string `json:"server"` // Name of the server in Octyne, defaults to its name in Peridot
+	Token    string `json:"token"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Merge returns the config with empty fields filled in from defaults.
+func (c OctyneConfig) Merge(defaults OctyneConfig) OctyneConfig {
+	return OctyneConfig{
+		URL:      PickNonEmptyString(c.URL, defaults.URL),
+		Server:   PickNonEmptyString(c.Server, defaults.Server),
+		Token:    PickNonEmptyString(c.Token, defaults.Token),
+		Username: PickNonEmptyString(c.Username, defaults.Username),
+		Password: PickNonEmptyString(c.Password, defaults.Password),
+	}
+}
+
+type InvalidOctyneURLError struct{ URL string }
+
+func (e InvalidOctyneURLError) Error() string {
+	return "invalid Octyne URL: " + e.URL + " (must start with unix://, http:// or https://)"
+}
+
+type OctyneClient struct {
+	baseURL  string
+	http     *http.Client
+	username string
+	password string
+
+	mutex sync.Mutex
+	token string
 }
 
 type OctyneServerStatus int
@@ -25,13 +61,107 @@ const (
 	OctyneServerStatusCrashed
 )
 
-func OctyneGetServers() (map[string]OctyneServerStatus, error) {
-	resp, err := httpc.Get("http://unix/servers?extrainfo=true")
+func NewOctyneClient(config OctyneConfig) (*OctyneClient, error) {
+	url := PickNonEmptyString(config.URL, DefaultOctyneURL)
+	client := &OctyneClient{
+		token:    config.Token,
+		username: config.Username,
+		password: config.Password,
+	}
+	if socket, ok := strings.CutPrefix(url, "unix://"); ok {
+		client.baseURL = "http://unix"
+		client.http = &http.Client{
+			Timeout: 30 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		}
+	} else if strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		client.baseURL = strings.TrimSuffix(url, "/")
+		client.http = &http.Client{Timeout: 30 * time.Second}
+	} else {
+		return nil, InvalidOctyneURLError{URL: url}
+	}
+	return client, nil
+}
+
+var errOctyneUnauthorized = errors.New("unauthorized")
+
+// login retrieves a token from Octyne using the configured username and password, if needed.
+func (c *OctyneClient) login(force bool) (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if (c.token != "" && !force) || c.username == "" {
+		return c.token, nil
+	}
+
+	req, err := http.NewRequest("GET", c.baseURL+"/login", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Username", c.username)
+	req.Header.Set("Password", c.password)
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("login response error %s", resp.Status)
+	}
+	var responseBody struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&responseBody); err != nil {
+		return "", fmt.Errorf("failed to decode login response: %v", err)
+	}
+	c.token = responseBody.Token
+	return c.token, nil
+}
+
+// request sends an authenticated request to Octyne, logging in again once if the token expired.
+func (c *OctyneClient) request(method, path string, body []byte) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		token, err := c.login(attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest(method, c.baseURL+path, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "text/plain")
+		}
+		resp, err := c.http.Do(req)
+		if err != nil {
+			return nil, err
+		} else if resp.StatusCode == 401 {
+			resp.Body.Close()
+			if attempt > 0 || c.username == "" {
+				return nil, errOctyneUnauthorized
+			}
+			continue
+		} else if resp.StatusCode != 200 {
+			resp.Body.Close()
+			return nil, fmt.Errorf("response error %s", resp.Status)
+		}
+		return resp, nil
+	}
+}
+
+func (c *OctyneClient) GetServers() (map[string]OctyneServerStatus, error) {
+	resp, err := c.request("GET", "/servers?extrainfo=true", nil)
 	if err != nil {
 		return nil, err
-	} else if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("response error %s", resp.Status)
 	}
+	defer resp.Body.Close()
 	var responseBody struct {
 		Servers map[string]struct {
 			Status OctyneServerStatus `json:"status"`
@@ -48,13 +178,12 @@ func OctyneGetServers() (map[string]OctyneServerStatus, error) {
 	return statuses, nil
 }
 
-func OctyneGetServerStatus(server string) (OctyneServerStatus, error) {
-	resp, err := httpc.Get("http://unix/server/" + server)
+func (c *OctyneClient) GetServerStatus(server string) (OctyneServerStatus, error) {
+	resp, err := c.request("GET", "/server/"+server, nil)
 	if err != nil {
 		return 0, err
-	} else if resp.StatusCode != 200 {
-		return 0, fmt.Errorf("response error %s", resp.Status)
 	}
+	defer resp.Body.Close()
 	var responseBody struct {
 		Status OctyneServerStatus `json:"status"`
 	}
@@ -64,21 +193,19 @@ func OctyneGetServerStatus(server string) (OctyneServerStatus, error) {
 	return responseBody.Status, nil
 }
 
-func OctyneStartServer(server string) error {
-	return octynePostServer(server, "START")
+func (c *OctyneClient) StartServer(server string) error {
+	return c.postServer(server, "START")
 }
 
-func OctyneTerminateServer(server string) error {
-	return octynePostServer(server, "TERM")
+func (c *OctyneClient) TerminateServer(server string) error {
+	return c.postServer(server, "TERM")
 }
 
-func octynePostServer(server, action string) error {
-	if resp, err := httpc.Post(
-		"http://unix/server/"+server, "text/plain", bytes.NewReader([]byte(action)),
-	); err != nil {
+func (c *OctyneClient) postServer(server, action string) error {
+	resp, err := c.request("POST", "/server/"+server, []byte(action))
+	if err != nil {
 		return err
-	} else if resp.StatusCode != 200 {
-		return fmt.Errorf("response error %s", resp.Status)
 	}
-	return nil
+	io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
 }
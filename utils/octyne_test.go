@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// octyneStub is a stub Octyne instance recording the requests made to it.
+type octyneStub struct {
+	mutex    sync.Mutex
+	token    string // Accepted token, empty if no authentication is required
+	username string
+	password string
+	logins   int
+	actions  []string // Bodies of POST /server/{name} requests
+}
+
+func (s *octyneStub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if r.URL.Path == "/login" {
+		if r.Header.Get("Username") != s.username || r.Header.Get("Password") != s.password {
+			http.Error(w, `{"error":"Invalid username or password!"}`, http.StatusUnauthorized)
+			return
+		}
+		s.logins++
+		json.NewEncoder(w).Encode(map[string]string{"token": s.token})
+		return
+	} else if s.token != "" && r.Header.Get("Authorization") != s.token {
+		http.Error(w, `{"error":"You are not authenticated to access this resource!"}`, http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/servers":
+		w.Write([]byte(`{"servers":{"hub":{"status":1},"lobby":{"status":0}}}`))
+	case r.Method == "GET" && r.URL.Path == "/server/hub":
+		w.Write([]byte(`{"status":2}`))
+	case r.Method == "POST" && r.URL.Path == "/server/hub":
+		body, _ := io.ReadAll(r.Body)
+		s.actions = append(s.actions, string(body))
+		w.Write([]byte(`{"success":true}`))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestOctyneClientToken(t *testing.T) {
+	stub := &octyneStub{token: "secret-token"}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	client, err := NewOctyneClient(OctyneConfig{URL: server.URL + "/", Token: "secret-token"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers, err := client.GetServers()
+	if err != nil {
+		t.Fatal(err)
+	} else if servers["hub"] != OctyneServerStatusRunning || servers["lobby"] != OctyneServerStatusStopped {
+		t.Errorf("GetServers() = %v, want hub running and lobby stopped", servers)
+	}
+	if status, err := client.GetServerStatus("hub"); err != nil {
+		t.Fatal(err)
+	} else if status != OctyneServerStatusCrashed {
+		t.Errorf("GetServerStatus(hub) = %v, want crashed", status)
+	}
+	if err := client.TerminateServer("hub"); err != nil {
+		t.Fatal(err)
+	} else if err := client.StartServer("hub"); err != nil {
+		t.Fatal(err)
+	} else if len(stub.actions) != 2 || stub.actions[0] != "TERM" || stub.actions[1] != "START" {
+		t.Errorf("actions = %v, want [TERM START]", stub.actions)
+	}
+	if stub.logins != 0 {
+		t.Errorf("logins = %d, want 0 with a token", stub.logins)
+	}
+
+	client, err = NewOctyneClient(OctyneConfig{URL: server.URL, Token: "wrong-token"})
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := client.GetServers(); err != errOctyneUnauthorized {
+		t.Errorf("GetServers() with a wrong token = %v, want %v", err, errOctyneUnauthorized)
+	}
+}
+
+func TestOctyneClientLogin(t *testing.T) {
+	stub := &octyneStub{token: "first-token", username: "admin", password: "hunter2"}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	client, err := NewOctyneClient(OctyneConfig{URL: server.URL, Username: "admin", Password: "hunter2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetServers(); err != nil {
+		t.Fatal(err)
+	} else if _, err := client.GetServerStatus("hub"); err != nil {
+		t.Fatal(err)
+	} else if stub.logins != 1 {
+		t.Errorf("logins = %d, want the token to be reused after logging in once", stub.logins)
+	}
+
+	// An expired token is replaced by logging in again
+	stub.mutex.Lock()
+	stub.token = "second-token"
+	stub.mutex.Unlock()
+	if err := client.TerminateServer("hub"); err != nil {
+		t.Fatal(err)
+	} else if stub.logins != 2 {
+		t.Errorf("logins = %d, want 2 after the token expired", stub.logins)
+	}
+
+	client, err = NewOctyneClient(OctyneConfig{URL: server.URL, Username: "admin", Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	} else if _, err := client.GetServers(); err == nil {
+		t.Error("GetServers() with a wrong password succeeded")
+	}
+}
+
+func TestOctyneClientUnixSocket(t *testing.T) {
+	// Unix socket paths are limited in length, so avoid the longer test temporary folder
+	dir, err := os.MkdirTemp("", "octyne")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "octyne.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip("unix sockets are not supported:", err)
+	}
+	stub := &octyneStub{}
+	server := httptest.NewUnstartedServer(stub)
+	server.Listener.Close()
+	server.Listener = listener
+	server.Start()
+	defer server.Close()
+
+	client, err := NewOctyneClient(OctyneConfig{URL: "unix://" + socket})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, err := client.GetServerStatus("hub"); err != nil {
+		t.Fatal(err)
+	} else if status != OctyneServerStatusCrashed {
+		t.Errorf("GetServerStatus(hub) = %v, want crashed", status)
+	}
+	if err := client.StartServer("hub"); err != nil {
+		t.Fatal(err)
+	} else if len(stub.actions) != 1 || stub.actions[0] != "START" {
+		t.Errorf("actions = %v, want [START]", stub.actions)
+	}
+}
+
+func TestNewOctyneClientInvalidURL(t *testing.T) {
+	if _, err := NewOctyneClient(OctyneConfig{URL: "ftp://localhost"}); err == nil {
+		t.Error("NewOctyneClient() with an ftp:// URL succeeded")
+	}
+}
@@ -0,0 +1,22 @@
+package utils
+
+import "sync"
+
+// RunParallel calls fn for each item, with at most parallelism calls running concurrently.
+func RunParallel[T any](items []T, parallelism int, fn func(item T)) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, parallelism)
+	for _, item := range items {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+			fn(item)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,260 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+)
+
+// Properties is a Java .properties file, which can be edited while keeping its comments, blank lines,
+// key order and line endings, with untouched lines written back exactly as they were.
+type Properties struct {
+	lines   []propertiesLine
+	newline string
+}
+
+type propertiesLine struct {
+	raw        string // Lines of a property continued with a backslash are joined with newlines
+	isProperty bool
+	key        string
+	value      string
+}
+
+// ParseProperties parses a .properties file following the format of java.util.Properties, with the
+// exception that the file is read as UTF-8 instead of ISO-8859-1.
+func ParseProperties(data []byte) *Properties {
+	text := string(data)
+	properties := &Properties{newline: "\n"}
+	if index := strings.IndexAny(text, "\r\n"); index != -1 && text[index] == '\r' {
+		properties.newline = "\r"
+		if strings.HasPrefix(text[index:], "\r\n") {
+			properties.newline = "\r\n"
+		}
+	}
+	physical := splitPropertiesLines(text)
+	if len(physical) > 0 && physical[len(physical)-1] == "" {
+		physical = physical[:len(physical)-1]
+	}
+
+	for i := 0; i < len(physical); i++ {
+		trimmed := strings.TrimLeft(physical[i], " \t\f")
+		if trimmed == "" || trimmed[0] == '#' || trimmed[0] == '!' {
+			properties.lines = append(properties.lines, propertiesLine{raw: physical[i]})
+			continue
+		}
+		// Join continuation lines, which end with an odd number of backslashes
+		raw := physical[i]
+		logical := trimmed
+		for isPropertiesContinued(logical) && i+1 < len(physical) {
+			i++
+			raw += "\n" + physical[i]
+			logical = logical[:len(logical)-1] + strings.TrimLeft(physical[i], " \t\f")
+		}
+		if isPropertiesContinued(logical) {
+			logical = logical[:len(logical)-1]
+		}
+		key, value := splitPropertiesKeyValue(logical)
+		properties.lines = append(properties.lines, propertiesLine{
+			raw:        raw,
+			isProperty: true,
+			key:        unescapeProperties(key),
+			value:      unescapeProperties(value),
+		})
+	}
+	return properties
+}
+
+// Get returns the value of a key. If the key is set more than once, the last value is returned.
+func (p *Properties) Get(key string) (string, bool) {
+	for i := len(p.lines) - 1; i >= 0; i-- {
+		if p.lines[i].isProperty && p.lines[i].key == key {
+			return p.lines[i].value, true
+		}
+	}
+	return "", false
+}
+
+// Keys returns every key in the file, in the order they first appear.
+func (p *Properties) Keys() []string {
+	keys := make([]string, 0, len(p.lines))
+	for _, line := range p.lines {
+		if line.isProperty && !slices.Contains(keys, line.key) {
+			keys = append(keys, line.key)
+		}
+	}
+	return keys
+}
+
+// Set sets the value of a key, replacing its last occurrence, or appending it to the end of the
+// file if it is missing.
+func (p *Properties) Set(key, value string) {
+	line := propertiesLine{
+		raw:        escapeProperties(key, true) + "=" + escapeProperties(value, false),
+		isProperty: true,
+		key:        key,
+		value:      value,
+	}
+	for i := len(p.lines) - 1; i >= 0; i-- {
+		if p.lines[i].isProperty && p.lines[i].key == key {
+			if p.lines[i].value != value {
+				p.lines[i] = line
+			}
+			return
+		}
+	}
+	p.lines = append(p.lines, line)
+}
+
+// Delete removes every occurrence of a key, returning whether it existed.
+func (p *Properties) Delete(key string) bool {
+	length := len(p.lines)
+	p.lines = slices.DeleteFunc(p.lines, func(line propertiesLine) bool {
+		return line.isProperty && line.key == key
+	})
+	return len(p.lines) != length
+}
+
+// Bytes returns the contents of the file, using its original line endings.
+func (p *Properties) Bytes() []byte {
+	var builder strings.Builder
+	for _, line := range p.lines {
+		builder.WriteString(strings.ReplaceAll(line.raw, "\n", p.newline))
+		builder.WriteString(p.newline)
+	}
+	return []byte(builder.String())
+}
+
+// splitPropertiesLines splits text on any of the line terminators supported by .properties files.
+func splitPropertiesLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := make([]string, 0)
+	start := 0
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' || text[i] == '\r' {
+			lines = append(lines, text[start:i])
+			if text[i] == '\r' && i+1 < len(text) && text[i+1] == '\n' {
+				i++
+			}
+			start = i + 1
+		}
+	}
+	return append(lines, text[start:])
+}
+
+func isPropertiesContinued(line string) bool {
+	backslashes := len(line) - len(strings.TrimRight(line, "\\"))
+	return backslashes%2 == 1
+}
+
+// splitPropertiesKeyValue splits a logical line into its escaped key and value. The key ends at the
+// first unescaped '=', ':' or whitespace, which may be surrounded by whitespace.
+func splitPropertiesKeyValue(line string) (string, string) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+		} else if strings.IndexByte("=: \t\f", line[i]) != -1 {
+			end = i
+			break
+		}
+	}
+	key, rest := line[:end], strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	return key, rest
+}
+
+func unescapeProperties(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			builder.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			builder.WriteByte('\t')
+		case 'n':
+			builder.WriteByte('\n')
+		case 'r':
+			builder.WriteByte('\r')
+		case 'f':
+			builder.WriteByte('\f')
+		case 'u':
+			code, ok := parseUnicodeEscape(s[i+1:])
+			if !ok {
+				builder.WriteByte('u') // Malformed unicode escape, keep it as is
+				break
+			}
+			i += 4
+			// Characters outside the BMP are escaped as a pair of UTF-16 surrogates
+			if utf16.IsSurrogate(code) && strings.HasPrefix(s[i+1:], `\u`) {
+				low, ok := parseUnicodeEscape(s[i+3:])
+				if decoded := utf16.DecodeRune(code, low); ok && decoded != unicode.ReplacementChar {
+					code = decoded
+					i += 6
+				}
+			}
+			builder.WriteRune(code)
+		default:
+			builder.WriteByte(s[i])
+		}
+	}
+	return builder.String()
+}
+
+// parseUnicodeEscape parses the 4 hex digits at the start of s.
+func parseUnicodeEscape(s string) (rune, bool) {
+	if len(s) < 4 {
+		return 0, false
+	}
+	code, err := strconv.ParseUint(s[:4], 16, 16)
+	return rune(code), err == nil
+}
+
+// escapeProperties escapes a key or value like java.util.Properties.store, with characters outside
+// of printable ASCII written as unicode escapes.
+func escapeProperties(s string, isKey bool) string {
+	var builder strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			builder.WriteString(`\\`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\n':
+			builder.WriteString(`\n`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\f':
+			builder.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			builder.WriteByte('\\')
+			builder.WriteRune(r)
+		case ' ':
+			if i == 0 || isKey {
+				builder.WriteByte('\\')
+			}
+			builder.WriteByte(' ')
+		default:
+			if r < 0x20 || r > 0x7e {
+				for _, unit := range utf16.Encode([]rune{r}) {
+					fmt.Fprintf(&builder, `\u%04X`, unit)
+				}
+			} else {
+				builder.WriteRune(r)
+			}
+		}
+	}
+	return builder.String()
+}
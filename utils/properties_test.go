@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePropertiesValues(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		key   string
+		want  string
+	}{
+		{"equals", "motd=A Minecraft Server\n", "motd", "A Minecraft Server"},
+		{"spaced equals", "motd = A Minecraft Server\n", "motd", "A Minecraft Server"},
+		{"colon", "motd:A Minecraft Server\n", "motd", "A Minecraft Server"},
+		{"spaced colon", "motd : A Minecraft Server\n", "motd", "A Minecraft Server"},
+		{"whitespace separator", "motd A Minecraft Server\n", "motd", "A Minecraft Server"},
+		{"leading whitespace", "   motd=hello\n", "motd", "hello"},
+		{"empty value", "level-seed=\n", "level-seed", ""},
+		{"escaped separator in key", "a\\=b=c\n", "a=b", "c"},
+		{"escaped space in key", "a\\ b=c\n", "a b", "c"},
+		{"escapes in value", "motd=tab\\there\\nnewline\\\\backslash\n", "motd", "tab\there\nnewline\\backslash"},
+		{"section sign", "motd=\\u00a7aGreen\n", "motd", "§aGreen"},
+		{"surrogate pair", "motd=\\uD83D\\uDE00\n", "motd", "😀"},
+		{"malformed unicode escape", "motd=\\u00zz\n", "motd", "u00zz"},
+		{"utf-8", "motd=§aGreen\n", "motd", "§aGreen"},
+		{"continuation", "motd=first \\\n    second\n", "motd", "first second"},
+		{"escaped backslash is no continuation", "a=b\\\\\nc=d\n", "a", "b\\"},
+		{"crlf", "a=b\r\nmotd=hello\r\n", "motd", "hello"},
+		{"cr", "a=b\rmotd=hello\r", "motd", "hello"},
+		{"no trailing newline", "motd=hello", "motd", "hello"},
+		{"last occurrence wins", "motd=first\nmotd=second\n", "motd", "second"},
+	}
+	for _, test := range tests {
+		got, ok := ParseProperties([]byte(test.input)).Get(test.key)
+		if !ok || got != test.want {
+			t.Errorf("%s: Get(%q) = %q, %t, want %q", test.name, test.key, got, ok, test.want)
+		}
+	}
+}
+
+func TestParsePropertiesComments(t *testing.T) {
+	properties := ParseProperties([]byte("#motd=commented\n! also=commented\n\nmotd=hello\n"))
+	if _, ok := properties.Get("#motd"); ok {
+		t.Error("# comment parsed as a property")
+	} else if _, ok := properties.Get("!"); ok {
+		t.Error("! comment parsed as a property")
+	} else if keys := properties.Keys(); len(keys) != 1 || keys[0] != "motd" {
+		t.Errorf("Keys() = %v, want [motd]", keys)
+	}
+}
+
+func TestPropertiesRoundTrip(t *testing.T) {
+	tests := []string{
+		"#Minecraft server properties\n#Sat Jan 01 00:00:00 UTC 2022\nmotd=A Minecraft Server\n",
+		"a = b\nc:d\ne f\n\n! comment\n",
+		"motd=first \\\n    second\nnext=value\n",
+		"motd=\\u00a7aGreen\r\nserver-port=25565\r\n",
+		"  indented = value  \nno-newline=value",
+	}
+	for _, input := range tests {
+		want := input
+		if want[len(want)-1] != '\n' {
+			want += "\n"
+		}
+		if got := string(ParseProperties([]byte(input)).Bytes()); got != want {
+			t.Errorf("round trip of %q = %q", input, got)
+		}
+	}
+}
+
+func TestPropertiesEdit(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		edit  func(p *Properties)
+		want  string
+	}{
+		{
+			"set keeps comments and order",
+			"#Comment\nmotd=old\n\nserver-port=25565\n",
+			func(p *Properties) { p.Set("motd", "new") },
+			"#Comment\nmotd=new\n\nserver-port=25565\n",
+		},
+		{
+			"set appends missing keys",
+			"#Comment\nmotd=hello\n",
+			func(p *Properties) { p.Set("server-port", "25566") },
+			"#Comment\nmotd=hello\nserver-port=25566\n",
+		},
+		{
+			"set keeps untouched formatting",
+			"motd : hello\nserver-port = 25565\n",
+			func(p *Properties) { p.Set("motd", "hello"); p.Set("server-port", "25566") },
+			"motd : hello\nserver-port=25566\n",
+		},
+		{
+			"set escapes values",
+			"",
+			func(p *Properties) { p.Set("motd", " §aHi: #1\n") },
+			"motd=\\ \\u00A7aHi\\: \\#1\\n\n",
+		},
+		{
+			"set escapes keys",
+			"",
+			func(p *Properties) { p.Set("a b=c", "d") },
+			"a\\ b\\=c=d\n",
+		},
+		{
+			"set keeps crlf",
+			"motd=old\r\nserver-port=25565\r\n",
+			func(p *Properties) { p.Set("motd", "new"); p.Set("pvp", "false") },
+			"motd=new\r\nserver-port=25565\r\npvp=false\r\n",
+		},
+		{
+			"set replaces continued lines",
+			"motd=first \\\n  second\nnext=value\n",
+			func(p *Properties) { p.Set("motd", "single") },
+			"motd=single\nnext=value\n",
+		},
+		{
+			"delete removes every occurrence",
+			"motd=first\n#Comment\nmotd=second\nserver-port=25565\n",
+			func(p *Properties) { p.Delete("motd") },
+			"#Comment\nserver-port=25565\n",
+		},
+		{
+			"delete removes keys with empty values",
+			"level-seed=\nresource-pack:\nmotd=hello\n",
+			func(p *Properties) { p.Delete("level-seed"); p.Delete("resource-pack") },
+			"motd=hello\n",
+		},
+		{
+			"delete removes continued lines",
+			"motd=first \\\r\n  second\r\nnext=value\r\n",
+			func(p *Properties) { p.Delete("motd") },
+			"next=value\r\n",
+		},
+	}
+	for _, test := range tests {
+		properties := ParseProperties([]byte(test.input))
+		test.edit(properties)
+		if got := string(properties.Bytes()); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+		// The written file must read back with the same values
+		reparsed := ParseProperties(properties.Bytes())
+		for _, key := range properties.Keys() {
+			want, _ := properties.Get(key)
+			if got, ok := reparsed.Get(key); !ok || got != want {
+				t.Errorf("%s: reparsed Get(%q) = %q, %t, want %q", test.name, key, got, ok, want)
+			}
+		}
+	}
+}
+
+func TestPropertiesDeleteMissing(t *testing.T) {
+	properties := ParseProperties([]byte("motd=hello\n"))
+	if properties.Delete("missing") {
+		t.Error("Delete() of a missing key returned true")
+	} else if !properties.Delete("motd") {
+		t.Error("Delete() of an existing key returned false")
+	}
+}
+
+func TestMergeProperties(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		patch   map[string]interface{}
+		want    string
+		changes []ConfigChange
+	}{
+		{
+			"updates and appends",
+			"#Comment\nmotd=old\nserver-port=25565\n",
+			map[string]interface{}{"motd": "§aNew", "max-players": 50},
+			"#Comment\nmotd=\\u00A7aNew\nserver-port=25565\nmax-players=50\n",
+			[]ConfigChange{{Key: "max-players", NewValue: "50"}, {Key: "motd", OldValue: "old", NewValue: "§aNew"}},
+		},
+		{
+			"flattens nested keys",
+			"query.port=25565\n",
+			map[string]interface{}{"query": map[string]interface{}{"port": 25566}},
+			"query.port=25566\n",
+			[]ConfigChange{{Key: "query.port", OldValue: "25565", NewValue: "25566"}},
+		},
+		{
+			"deletes keys with empty values",
+			"level-seed=\nmotd=hello\n",
+			map[string]interface{}{"level-seed": nil, "missing": nil},
+			"motd=hello\n",
+			[]ConfigChange{{Key: "level-seed"}},
+		},
+		{
+			"keeps unchanged files byte for byte",
+			"motd : hello\r\nserver-port = 25565\r\n",
+			map[string]interface{}{"motd": "hello", "server-port": 25565, "missing": nil},
+			"motd : hello\r\nserver-port = 25565\r\n",
+			[]ConfigChange{},
+		},
+	}
+	for _, test := range tests {
+		got, changes, err := mergeProperties([]byte(test.input), test.patch)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(got) != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+		if !slices.Equal(changes, test.changes) {
+			t.Errorf("%s: changes = %v, want %v", test.name, changes, test.changes)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Ranks of version qualifiers, following Maven's ordering. Unknown qualifiers are ranked after
+// all known ones, and compared with each other alphabetically.
+const (
+	qualifierAlpha = iota
+	qualifierBeta
+	qualifierMilestone
+	qualifierRC
+	qualifierSnapshot
+	qualifierRelease
+	qualifierServicePack
+	qualifierBuild
+	qualifierUnknown
+)
+
+var versionQualifiers = map[string]int{
+	"alpha": qualifierAlpha, "a": qualifierAlpha,
+	"beta":      qualifierBeta,
+	"milestone": qualifierMilestone, "m": qualifierMilestone,
+	"rc": qualifierRC, "cr": qualifierRC, "pre": qualifierRC, "preview": qualifierRC,
+	"snapshot": qualifierSnapshot, "dev": qualifierSnapshot,
+	"": qualifierRelease, "ga": qualifierRelease, "final": qualifierRelease, "release": qualifierRelease,
+	"sp": qualifierServicePack,
+	"b":  qualifierBuild, "build": qualifierBuild,
+}
+
+// gitHashPattern matches commit hashes in versions, optionally prefixed with g as in git describe.
+var gitHashPattern = regexp.MustCompile(`^g?([0-9a-f]{7,40})$`)
+
+// qualifiedNumberPattern matches a qualifier followed by a number, e.g. b1234567 or a1.
+var qualifiedNumberPattern = regexp.MustCompile(`^([a-z]+)[0-9]+$`)
+
+// isGitHash checks if a part of a version is a commit hash, which has both digits and letters,
+// unlike build numbers and dates. Qualifiers followed by a number, such as the build b1234567, are
+// never hashes, even though they are valid hexadecimal.
+func isGitHash(part string) bool {
+	match := gitHashPattern.FindStringSubmatch(part)
+	if match == nil || !strings.ContainsAny(match[1], "0123456789") || !strings.ContainsAny(match[1], "abcdef") {
+		return false
+	} else if qualified := qualifiedNumberPattern.FindStringSubmatch(part); qualified != nil {
+		_, ok := versionQualifiers[qualified[1]]
+		return !ok
+	}
+	return true
+}
+
+type versionItem struct {
+	isNumber  bool
+	number    string // Without leading zeros
+	qualifier int
+	text      string // Only set for unknown qualifiers
+}
+
+// CompareVersions compares two versions, returning -1 if a is older than b, 1 if a is newer than
+// b, and 0 if they are equivalent. Versions are split into numbers and qualifiers, so that semantic
+// versions (1.10.0 > 1.9.0), Maven qualifiers (1.0-beta < 1.0-RC1 < 1.0-SNAPSHOT < 1.0 < 1.0-b1234)
+// and build numbers are ordered as expected. Git commit hashes and semver build metadata (after a +)
+// are ignored, as they can't be ordered.
+func CompareVersions(a, b string) int {
+	itemsA, itemsB := parseVersion(a), parseVersion(b)
+	for i := 0; i < len(itemsA) || i < len(itemsB); i++ {
+		// Missing items are padded with zeros, or the release qualifier
+		var itemA, itemB versionItem
+		if i < len(itemsA) {
+			itemA = itemsA[i]
+		}
+		if i < len(itemsB) {
+			itemB = itemsB[i]
+		}
+		if i >= len(itemsA) {
+			itemA = versionItem{isNumber: itemB.isNumber, qualifier: qualifierRelease}
+		} else if i >= len(itemsB) {
+			itemB = versionItem{isNumber: itemA.isNumber, qualifier: qualifierRelease}
+		}
+		if result := compareVersionItems(itemA, itemB); result != 0 {
+			return result
+		}
+	}
+	return 0
+}
+
+func compareVersionItems(a, b versionItem) int {
+	switch {
+	case a.isNumber && b.isNumber:
+		if len(a.number) != len(b.number) {
+			return compareInts(len(a.number), len(b.number))
+		}
+		return strings.Compare(a.number, b.number)
+	case a.isNumber:
+		return 1 // Numbers are newer than qualifiers, e.g. 1.0.1 > 1.0-SNAPSHOT
+	case b.isNumber:
+		return -1
+	case a.qualifier != b.qualifier:
+		return compareInts(a.qualifier, b.qualifier)
+	}
+	return strings.Compare(a.text, b.text)
+}
+
+func compareInts(a, b int) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// parseVersion splits a version into numbers and qualifiers, on separators and on transitions
+// between digits and letters, trimming trailing zeros and release qualifiers (1.0.0 == 1).
+func parseVersion(version string) []versionItem {
+	version = strings.ToLower(strings.TrimSpace(version))
+	version, _, _ = strings.Cut(version, "+")
+	if len(version) > 1 && version[0] == 'v' && version[1] >= '0' && version[1] <= '9' {
+		version = version[1:]
+	}
+
+	items := make([]versionItem, 0)
+	parts := strings.FieldsFunc(version, func(r rune) bool {
+		return r == '.' || r == '-' || r == '_' || r == ' ' || r == '(' || r == ')'
+	})
+	for i := 0; i < len(parts); i++ {
+		part := parts[i]
+		if part == "git" {
+			i++ // Skip the commit hash following it
+			continue
+		} else if isGitHash(part) {
+			continue
+		}
+		for part != "" {
+			isNumber := part[0] >= '0' && part[0] <= '9'
+			end := strings.IndexFunc(part, func(r rune) bool { return (r >= '0' && r <= '9') != isNumber })
+			if end == -1 {
+				end = len(part)
+			}
+			token := part[:end]
+			part = part[end:]
+			if isNumber {
+				items = append(items, versionItem{isNumber: true, number: strings.TrimLeft(token, "0")})
+				continue
+			}
+			// Zeros before a qualifier are insignificant, e.g. 1.0.0-RC1 == 1-RC1
+			for len(items) > 0 && items[len(items)-1].isNumber && items[len(items)-1].number == "" {
+				items = items[:len(items)-1]
+			}
+			if rank, ok := versionQualifiers[token]; ok {
+				items = append(items, versionItem{qualifier: rank})
+			} else {
+				items = append(items, versionItem{qualifier: qualifierUnknown, text: token})
+			}
+		}
+	}
+
+	// Trailing zeros and release qualifiers are equivalent to missing items
+	for len(items) > 0 {
+		last := items[len(items)-1]
+		if (last.isNumber && last.number == "") || (!last.isNumber && last.qualifier == qualifierRelease) {
+			items = items[:len(items)-1]
+		} else {
+			break
+		}
+	}
+	return items
+}
+
+// VersionConstraint is a list of version comparisons which must all match, e.g. ">=2.0.33 <2.1".
+// A version without an operator must be equivalent to the given version. An empty constraint
+// matches every version. Range operators such as ~ and ^ and wildcards aren't supported.
+type VersionConstraint []versionComparison
+
+type versionComparison struct {
+	Operator string
+	Version  string
+}
+
+type InvalidVersionConstraintError struct{ Constraint string }
+
+func (e InvalidVersionConstraintError) Error() string {
+	return "invalid version constraint '" + e.Constraint + "': must be versions optionally prefixed " +
+		"with one of =, !=, >, >=, < or <=, separated by spaces (~, ^ and wildcards aren't supported)"
+}
+
+var versionOperators = []string{">=", "<=", "!=", "==", ">", "<", "="}
+
+// ParseVersionConstraint parses a version constraint, with comparisons separated by spaces or commas.
+// A constraint without any operator is a single version, which may contain spaces.
+func ParseVersionConstraint(constraint string) (VersionConstraint, error) {
+	if version := strings.TrimSpace(constraint); version == "" {
+		return nil, nil
+	} else if !strings.ContainsAny(version, "<>=!") {
+		if !isConstraintVersion(version) {
+			return nil, InvalidVersionConstraintError{Constraint: constraint}
+		}
+		return VersionConstraint{{Operator: "=", Version: version}}, nil
+	}
+	fields := strings.Fields(strings.ReplaceAll(constraint, ",", " "))
+	comparisons := make(VersionConstraint, 0, len(fields))
+	for i := 0; i < len(fields); i++ {
+		comparison := versionComparison{Operator: "=", Version: fields[i]}
+		for _, operator := range versionOperators {
+			if strings.HasPrefix(fields[i], operator) {
+				comparison = versionComparison{Operator: operator, Version: fields[i][len(operator):]}
+				break
+			}
+		}
+		// Allow a space between the operator and the version
+		if comparison.Version == "" && i+1 < len(fields) {
+			i++
+			comparison.Version = fields[i]
+		}
+		if !isConstraintVersion(comparison.Version) || strings.ContainsAny(comparison.Version, "<>=!") {
+			return nil, InvalidVersionConstraintError{Constraint: constraint}
+		}
+		comparisons = append(comparisons, comparison)
+	}
+	return comparisons, nil
+}
+
+// isConstraintVersion checks if a version in a constraint is a plain version, rejecting unsupported
+// operators like ~1.2 and ^1.2, and wildcards like 1.2.*, which would otherwise never match.
+func isConstraintVersion(version string) bool {
+	if version == "" || strings.Contains(version, "*") {
+		return false
+	}
+	first := version[0]
+	return (first >= '0' && first <= '9') || (first >= 'a' && first <= 'z') || (first >= 'A' && first <= 'Z')
+}
+
+// Matches checks if a version matches every comparison of the constraint.
+func (c VersionConstraint) Matches(version string) bool {
+	for _, comparison := range c {
+		result := CompareVersions(version, comparison.Version)
+		var matches bool
+		switch comparison.Operator {
+		case "=", "==":
+			matches = result == 0
+		case "!=":
+			matches = result != 0
+		case ">":
+			matches = result > 0
+		case ">=":
+			matches = result >= 0
+		case "<":
+			matches = result < 0
+		case "<=":
+			matches = result <= 0
+		}
+		if !matches {
+			return false
+		}
+	}
+	return true
+}
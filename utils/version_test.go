@@ -0,0 +1,74 @@
+package utils
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.10.0", "1.9.0", 1},
+		{"5.4.102", "5.4.99", 1},
+		{"1.0", "1.0.0", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.0-alpha", "1.0-beta", -1},
+		{"1.0-beta", "1.0-RC1", -1},
+		{"1.0-RC1", "1.0-RC2", -1},
+		{"1.0-RC1", "1.0-SNAPSHOT", -1},
+		{"1.0-SNAPSHOT", "1.0", -1},
+		{"1.0", "1.0-b1234", -1},
+		{"1.0-b1234", "1.0-b1235", -1},
+		{"1.0.1", "1.0-SNAPSHOT", 1},
+		{"1.0.0-RC1", "1-RC1", 0},
+		{"1.2.3+build.5", "1.2.3", 0},
+		{"3.3.0-SNAPSHOT (git-abc1234-b400)", "3.3.0-SNAPSHOT (git-def5678-b401)", -1},
+		{"2.0.33-gabc1234", "2.0.33", 0},
+		{"2.0.33-abc1234", "2.0.33", 0},
+		{"2.0-b1234567", "2.0-b1234568", -1},
+		{"2.0", "2.0-b1234567", -1},
+		{"2.0-a1234567", "2.0", -1},
+		{"2.0-build1234567", "2.0-b1234568", -1},
+		{"2.0-b1234567-gabc1234", "2.0-b1234567-gdef5678", 0},
+	}
+	for _, test := range tests {
+		if got := CompareVersions(test.a, test.b); got != test.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", test.a, test.b, got, test.want)
+		}
+		if got := CompareVersions(test.b, test.a); got != -test.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", test.b, test.a, got, -test.want)
+		}
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		want       bool
+	}{
+		{"", "1.0", true},
+		{"5.4.102", "5.4.102", true},
+		{"5.4.102", "5.4.99", false},
+		{">=2.0.33 <2.1", "2.0.40", true},
+		{">=2.0.33 <2.1", "2.1.0", false},
+		{">= 2.0.33, < 2.1", "2.0.33", true},
+		{"!=1.0", "1.0.0", false},
+		{"3.3.0-SNAPSHOT (git-abc1234-b400)", "3.3.0-SNAPSHOT (git-def5678-b400)", true},
+	}
+	for _, test := range tests {
+		constraint, err := ParseVersionConstraint(test.constraint)
+		if err != nil {
+			t.Errorf("ParseVersionConstraint(%q) failed: %v", test.constraint, err)
+		} else if got := constraint.Matches(test.version); got != test.want {
+			t.Errorf("%q matches %q = %t, want %t", test.constraint, test.version, got, test.want)
+		}
+	}
+}
+
+func TestParseVersionConstraintErrors(t *testing.T) {
+	for _, constraint := range []string{"~1.2", "^1.2", "1.2.*", "*", ">=~1.2", "<2.0 ^1.2", ">=", "1.0 <=>2"} {
+		if _, err := ParseVersionConstraint(constraint); err == nil {
+			t.Errorf("ParseVersionConstraint(%q) succeeded, want an error", constraint)
+		}
+	}
+}